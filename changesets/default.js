@@ -1,9 +0,0 @@
-//mongeez formatted javascript
-
-//changeset nwagensonner:userUniqueUsername
-db.user.remove({});
-
-db.user.createIndex(
-    {username : 1}, 
-    {unique: true}
-);
\ No newline at end of file
@@ -4,14 +4,21 @@ package common
 type Response struct {
 	Res  interface{}
 	Code int
+	Meta map[string]interface{}
 }
 
 // Metadata returns additional meta data
 func (r Response) Metadata() map[string]interface{} {
-	return map[string]interface{}{
+	meta := map[string]interface{}{
 		"author":  "The manyminds crew",
 		"license": "MIT",
 	}
+
+	for key, value := range r.Meta {
+		meta[key] = value
+	}
+
+	return meta
 }
 
 // Result returns the actual payload
@@ -0,0 +1,366 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/cli"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	//EnvConfigFile is the path to an optional json or yaml config file
+	EnvConfigFile = "SOYFR_CONFIG"
+	//EnvServerPort constant contains the name for the env variable to define the port
+	//where the server listens.
+	EnvServerPort = "SOYFR_SERVER_PORT"
+	//EnvConnectionURI is the mgo uri to the database or replica set
+	EnvConnectionURI = "SOYFR_CONNECTION_URI"
+	//EnvDatabase constant is the mongo database name for this application.
+	EnvDatabase = "SOYFR_DATABASE"
+	//EnvResourceFiles is the relative or absolute path to the folder where
+	//the static frontend files are
+	EnvResourceFiles = "SOYFR_RESOURCE_FILES"
+	//EnvSessionSecret is the key used to sign session tokens
+	EnvSessionSecret = "SOYFR_SESSION_SECRET"
+	//EnvMigrate applies pending migrations before the server starts
+	EnvMigrate = "SOYFR_MIGRATE"
+	//EnvBroadcast selects how socket messages reach the other instances
+	EnvBroadcast = "SOYFR_BROADCAST"
+	//EnvPublicURL is the address players use to reach the frontend,
+	//invite links and their qr codes point to it
+	EnvPublicURL = "SOYFR_PUBLIC_URL"
+	//EnvChatWords is a comma separated list of words the chat stars out
+	EnvChatWords = "SOYFR_CHAT_WORDS"
+	//EnvGracePeriod is the number of seconds a player that lost the
+	//connection keeps the seat in a game
+	EnvGracePeriod = "SOYFR_GRACE_PERIOD"
+
+	//EnvDockerConnection is set by a docker link to a mongo container,
+	//it is only used if SOYFR_CONNECTION_URI is not set
+	EnvDockerConnection = "MONGODB_PORT_27017_TCP"
+	//EnvDirectConnection is the former name of SOYFR_CONNECTION_URI
+	EnvDirectConnection = "MONGO_CONNECTION_STRING"
+)
+
+const (
+	//BroadcastMemory only reaches the sockets of the same process
+	BroadcastMemory = "memory"
+	//BroadcastMongo shares socket messages with every instance that uses
+	//the same database
+	BroadcastMongo = "mongo"
+)
+
+//Config is everything the application can be configured with. Values are
+//taken from the defaults, a config file, SOYFR_* env vars and flags,
+//every source overrides the ones before.
+type Config struct {
+	ConnectionURI     string   `yaml:"connectionUri"`
+	Database          string   `yaml:"database"`
+	Port              int      `yaml:"port"`
+	ResourceDirectory string   `yaml:"resourceDirectory"`
+	SessionSecret     string   `yaml:"sessionSecret"`
+	Migrate           bool     `yaml:"migrate"`
+	Broadcast         string   `yaml:"broadcast"`
+	PublicURL         string   `yaml:"publicUrl"`
+	ChatWords         []string `yaml:"chatWords"`
+	GracePeriod       int      `yaml:"gracePeriod"`
+}
+
+//Default returns the configuration for local development
+func Default() Config {
+	return Config{
+		ConnectionURI:     "localhost:27017",
+		Database:          "soyfr_development",
+		Port:              8800,
+		ResourceDirectory: "./public",
+		Broadcast:         BroadcastMemory,
+		GracePeriod:       60,
+	}
+}
+
+//Bongo returns the connection settings for bongo
+func (c Config) Bongo() *bongo.Config {
+	return &bongo.Config{
+		ConnectionString: c.ConnectionURI,
+		Database:         c.Database,
+	}
+}
+
+//Validate checks values that can not be used
+func (c Config) Validate() error {
+	if c.ConnectionURI == "" {
+		return errors.New("connectionUri must not be empty")
+	}
+
+	if c.Database == "" {
+		return errors.New("database must not be empty")
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d is not a valid port", c.Port)
+	}
+
+	if c.Broadcast != BroadcastMemory && c.Broadcast != BroadcastMongo {
+		return fmt.Errorf("broadcast must be %s or %s", BroadcastMemory, BroadcastMongo)
+	}
+
+	if c.GracePeriod < 0 {
+		return errors.New("gracePeriod must not be negative")
+	}
+
+	if c.PublicURL != "" && !strings.HasPrefix(c.PublicURL, "http://") && !strings.HasPrefix(c.PublicURL, "https://") {
+		return fmt.Errorf("publicUrl %s must start with http:// or https://", c.PublicURL)
+	}
+
+	return nil
+}
+
+//ReadFile overrides all values that are set in a json or yaml file
+func (c *Config) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	//json documents are valid yaml
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	return nil
+}
+
+//ReadEnv overrides all values whose env var is set, getenv is os.Getenv
+//outside of tests
+func (c *Config) ReadEnv(getenv func(string) string) error {
+	if docker := getenv(EnvDockerConnection); docker != "" {
+		c.ConnectionURI = strings.Replace(docker, "tcp://", "", 1)
+	}
+
+	for _, name := range []string{EnvDirectConnection, EnvConnectionURI} {
+		if uri := getenv(name); uri != "" {
+			c.ConnectionURI = uri
+		}
+	}
+
+	if database := getenv(EnvDatabase); database != "" {
+		c.Database = database
+	}
+
+	if port := getenv(EnvServerPort); port != "" {
+		value, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", EnvServerPort)
+		}
+
+		c.Port = value
+	}
+
+	if resources := getenv(EnvResourceFiles); resources != "" {
+		c.ResourceDirectory = resources
+	}
+
+	if secret := getenv(EnvSessionSecret); secret != "" {
+		c.SessionSecret = secret
+	}
+
+	if migrate := getenv(EnvMigrate); migrate != "" {
+		value, err := strconv.ParseBool(migrate)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", EnvMigrate)
+		}
+
+		c.Migrate = value
+	}
+
+	if broadcast := getenv(EnvBroadcast); broadcast != "" {
+		c.Broadcast = broadcast
+	}
+
+	if publicURL := getenv(EnvPublicURL); publicURL != "" {
+		c.PublicURL = publicURL
+	}
+
+	if words := getenv(EnvChatWords); words != "" {
+		c.ChatWords = splitWords(words)
+	}
+
+	if grace := getenv(EnvGracePeriod); grace != "" {
+		value, err := strconv.Atoi(grace)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", EnvGracePeriod)
+		}
+
+		c.GracePeriod = value
+	}
+
+	return nil
+}
+
+//Flags returns the global flags of the application, they show the
+//defaults and env vars in the help
+func Flags() []cli.Flag {
+	defaults := Default()
+
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "path to a json or yaml config file",
+			EnvVar: EnvConfigFile,
+		},
+		cli.StringFlag{
+			Name:   "connectionUri",
+			Value:  defaults.ConnectionURI,
+			Usage:  "mgo uri to the database or replica set",
+			EnvVar: EnvConnectionURI,
+		},
+		cli.IntFlag{
+			Name:   "port",
+			Value:  defaults.Port,
+			Usage:  "port the server listens on",
+			EnvVar: EnvServerPort,
+		},
+		cli.StringFlag{
+			Name:   "database",
+			Value:  defaults.Database,
+			Usage:  "some name for a database",
+			EnvVar: EnvDatabase,
+		},
+		cli.StringFlag{
+			Name:   "resourceDirectory",
+			Value:  defaults.ResourceDirectory,
+			Usage:  "path to the resource files",
+			EnvVar: EnvResourceFiles,
+		},
+		cli.StringFlag{
+			Name:   "sessionSecret",
+			Usage:  "secret key to sign session tokens",
+			EnvVar: EnvSessionSecret,
+		},
+		cli.BoolFlag{
+			Name:   "migrate",
+			Usage:  "apply pending migrations on startup",
+			EnvVar: EnvMigrate,
+		},
+		cli.StringFlag{
+			Name:   "broadcast",
+			Value:  defaults.Broadcast,
+			Usage:  "memory for a single instance or mongo to share game rooms between instances",
+			EnvVar: EnvBroadcast,
+		},
+		cli.StringFlag{
+			Name:   "publicUrl",
+			Usage:  "address of the frontend for invite links, the host of the request if empty",
+			EnvVar: EnvPublicURL,
+		},
+		cli.StringFlag{
+			Name:   "chatWords",
+			Usage:  "comma separated words the chat stars out",
+			EnvVar: EnvChatWords,
+		},
+		cli.IntFlag{
+			Name:   "gracePeriod",
+			Value:  defaults.GracePeriod,
+			Usage:  "seconds a player that lost the connection keeps the seat",
+			EnvVar: EnvGracePeriod,
+		},
+	}
+}
+
+//readFlags overrides all values whose flag was given on the command line
+func (c *Config) readFlags(context *cli.Context) {
+	if context.IsSet("connectionUri") {
+		c.ConnectionURI = context.String("connectionUri")
+	}
+
+	if context.IsSet("database") {
+		c.Database = context.String("database")
+	}
+
+	if context.IsSet("port") {
+		c.Port = context.Int("port")
+	}
+
+	if context.IsSet("resourceDirectory") {
+		c.ResourceDirectory = context.String("resourceDirectory")
+	}
+
+	if context.IsSet("sessionSecret") {
+		c.SessionSecret = context.String("sessionSecret")
+	}
+
+	if context.IsSet("migrate") {
+		c.Migrate = context.Bool("migrate")
+	}
+
+	if context.IsSet("broadcast") {
+		c.Broadcast = context.String("broadcast")
+	}
+
+	if context.IsSet("publicUrl") {
+		c.PublicURL = context.String("publicUrl")
+	}
+
+	if context.IsSet("chatWords") {
+		c.ChatWords = splitWords(context.String("chatWords"))
+	}
+
+	if context.IsSet("gracePeriod") {
+		c.GracePeriod = context.Int("gracePeriod")
+	}
+}
+
+//splitWords returns the words of a comma separated list
+func splitWords(list string) []string {
+	var words []string
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, word)
+		}
+	}
+
+	return words
+}
+
+//Load returns the configuration of the application, it must be called
+//with the context of the application and not of a command
+func Load(context *cli.Context) (Config, error) {
+	config := Default()
+
+	//the config flag falls back to SOYFR_CONFIG
+	if path := context.String("config"); path != "" {
+		if err := config.ReadFile(path); err != nil {
+			return config, err
+		}
+	}
+
+	if err := config.ReadEnv(os.Getenv); err != nil {
+		return config, err
+	}
+
+	config.readFlags(context)
+
+	return config, config.Validate()
+}
+
+//Print writes the configuration as yaml, the session secret is hidden
+func (c Config) Print(out io.Writer) error {
+	if c.SessionSecret != "" {
+		c.SessionSecret = "********"
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(data)
+	return err
+}
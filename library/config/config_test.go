@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/codegangsta/cli"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var dir string
+
+	env := func(values map[string]string) func(string) string {
+		return func(name string) string {
+			return values[name]
+		}
+	}
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	context := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("soyfr", flag.ContinueOnError)
+		for _, f := range Flags() {
+			f.Apply(set)
+		}
+
+		Expect(set.Parse(args)).To(Succeed())
+		return cli.NewContext(nil, set, set)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "soyfr-config")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should have usable defaults", func() {
+		Expect(Default().Validate()).To(Succeed())
+		Expect(Default().Bongo().Database).To(Equal("soyfr_development"))
+	})
+
+	It("Should read yaml and json files", func() {
+		conf := Default()
+		Expect(conf.ReadFile(writeFile("soyfr.yml", "database: party\nport: 9000\n"))).To(Succeed())
+		Expect(conf.Database).To(Equal("party"))
+		Expect(conf.Port).To(Equal(9000))
+		Expect(conf.ResourceDirectory).To(Equal("./public"))
+
+		Expect(conf.ReadFile(writeFile("soyfr.json", `{"sessionSecret": "secret", "migrate": true}`))).To(Succeed())
+		Expect(conf.SessionSecret).To(Equal("secret"))
+		Expect(conf.Migrate).To(BeTrue())
+		Expect(conf.Database).To(Equal("party"))
+	})
+
+	It("Should prefer SOYFR_CONNECTION_URI over the former variables", func() {
+		conf := Default()
+		Expect(conf.ReadEnv(env(map[string]string{EnvDockerConnection: "tcp://10.0.0.2:27017"}))).To(Succeed())
+		Expect(conf.ConnectionURI).To(Equal("10.0.0.2:27017"))
+
+		Expect(conf.ReadEnv(env(map[string]string{
+			EnvDockerConnection: "tcp://10.0.0.2:27017",
+			EnvDirectConnection: "10.0.0.3",
+			EnvConnectionURI:    "mongodb://10.0.0.4",
+		}))).To(Succeed())
+		Expect(conf.ConnectionURI).To(Equal("mongodb://10.0.0.4"))
+	})
+
+	It("Should refuse invalid env vars", func() {
+		conf := Default()
+		Expect(conf.ReadEnv(env(map[string]string{EnvServerPort: "high"}))).ToNot(Succeed())
+		Expect(conf.ReadEnv(env(map[string]string{EnvMigrate: "maybe"}))).ToNot(Succeed())
+		Expect(conf.ReadEnv(env(map[string]string{EnvGracePeriod: "a minute"}))).ToNot(Succeed())
+
+		conf.Port = 0
+		Expect(conf.Validate()).ToNot(Succeed())
+
+		conf = Default()
+		conf.GracePeriod = -1
+		Expect(conf.Validate()).ToNot(Succeed())
+	})
+
+	It("Should only know the memory and mongo broadcast", func() {
+		conf := Default()
+		Expect(conf.ReadEnv(env(map[string]string{EnvBroadcast: BroadcastMongo}))).To(Succeed())
+		Expect(conf.Validate()).To(Succeed())
+
+		conf.Broadcast = "redis"
+		Expect(conf.Validate()).ToNot(Succeed())
+	})
+
+	It("Should only accept absolute public urls", func() {
+		conf := Default()
+		Expect(conf.ReadEnv(env(map[string]string{EnvPublicURL: "https://soyfr.example"}))).To(Succeed())
+		Expect(conf.PublicURL).To(Equal("https://soyfr.example"))
+		Expect(conf.Validate()).To(Succeed())
+
+		conf.PublicURL = "soyfr.example"
+		Expect(conf.Validate()).ToNot(Succeed())
+	})
+
+	It("Should read the chat words as a comma separated list", func() {
+		conf := Default()
+		Expect(conf.ReadEnv(env(map[string]string{EnvChatWords: "beer, ,Wine"}))).To(Succeed())
+		Expect(conf.ChatWords).To(Equal([]string{"beer", "Wine"}))
+
+		Expect(conf.ReadFile(writeFile("soyfr.yml", "chatWords: [water]\n"))).To(Succeed())
+		Expect(conf.ChatWords).To(Equal([]string{"water"}))
+	})
+
+	It("Should let flags override the file", func() {
+		path := writeFile("soyfr.yml", "database: party\nport: 9000\n")
+		conf, err := Load(context("--config", path, "--port", "9100"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.Database).To(Equal("party"))
+		Expect(conf.Port).To(Equal(9100))
+	})
+
+	It("Should hide the session secret when printing", func() {
+		conf := Default()
+		conf.SessionSecret = "secret"
+		out := &bytes.Buffer{}
+		Expect(conf.Print(out)).To(Succeed())
+		Expect(out.String()).ToNot(ContainSubstring("secret\n"))
+		Expect(out.String()).To(ContainSubstring("database: soyfr_development"))
+	})
+})
@@ -0,0 +1,268 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//broadcastCollection is the capped collection that all instances tail
+const broadcastCollection = "broadcast"
+
+//broadcastSize is the size of the broadcast collection in bytes,
+//the oldest messages are dropped when it is full
+const broadcastSize = 16 << 20
+
+//ErrBroadcastNotCapped is returned if the broadcast collection is
+//missing or not capped, a tailable cursor needs a capped collection
+var ErrBroadcastNotCapped = errors.New("the broadcast collection is missing or not capped, drop it and run the migrations")
+
+//tailTimeout is how long the cursor waits for new messages before
+//the adaptor checks if it was closed
+const tailTimeout = time.Second
+
+//rawJSON is an argument that was encoded by another instance,
+//it is emitted as it is
+type rawJSON string
+
+//MarshalJSON satisfies the json.Marshaler interface
+func (r rawJSON) MarshalJSON() ([]byte, error) {
+	return []byte(r), nil
+}
+
+//broadcastMessage is a room message in the broadcast collection,
+//the arguments are stored as json to look the same on every instance
+type broadcastMessage struct {
+	ID      bson.ObjectId `bson:"_id"`
+	Origin  string
+	Room    string
+	Message string
+	Ignore  string
+	Args    []string
+	Created time.Time
+}
+
+//socketRooms keeps the sockets of this instance by room
+type socketRooms struct {
+	sync.Mutex
+	rooms map[string]map[string]socketio.Socket
+}
+
+func (s *socketRooms) join(room string, socket socketio.Socket) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.rooms[room]; !ok {
+		s.rooms[room] = make(map[string]socketio.Socket)
+	}
+
+	s.rooms[room][socket.Id()] = socket
+}
+
+func (s *socketRooms) leave(room string, socket socketio.Socket) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.rooms[room], socket.Id())
+	if len(s.rooms[room]) == 0 {
+		delete(s.rooms, room)
+	}
+}
+
+//send emits to every socket of the room except the ignored one,
+//the lock is not held while emitting
+func (s *socketRooms) send(ignore, room, message string, args ...interface{}) {
+	s.Lock()
+	sockets := make([]socketio.Socket, 0, len(s.rooms[room]))
+	for id, socket := range s.rooms[room] {
+		if id != ignore {
+			sockets = append(sockets, socket)
+		}
+	}
+	s.Unlock()
+
+	for _, socket := range sockets {
+		socket.Emit(message, args...)
+	}
+}
+
+//MongoAdaptor is a socket.io broadcast adaptor that shares room messages
+//with every instance connected to the same database. Messages are written
+//to a capped collection that all instances tail, sockets of the sending
+//instance get them right away. Only messages are shared, the rooms, turns
+//and votes of a game stay on the instance its players are connected to.
+type MongoAdaptor struct {
+	local      *socketRooms
+	session    *mgo.Session
+	collection *mgo.Collection
+	origin     string
+	timeout    time.Duration
+	closed     chan struct{}
+	done       chan struct{}
+}
+
+//NewMongoAdaptor starts to tail the broadcast collection, only messages
+//sent after this call are delivered. The collection is created by the
+//migrations, without them ErrBroadcastNotCapped is returned.
+func NewMongoAdaptor(connection *bongo.Connection) (*MongoAdaptor, error) {
+	return newMongoAdaptor(connection, tailTimeout)
+}
+
+func newMongoAdaptor(connection *bongo.Connection, timeout time.Duration) (*MongoAdaptor, error) {
+	session := connection.Session.Copy()
+	database := session.DB(connection.Config.Database)
+	collection := database.C(broadcastCollection)
+
+	//a missing collection is a failed command, tailing a collection
+	//that is not capped fails on every attempt
+	stats := struct{ Capped bool }{}
+	err := database.Run(bson.D{{Name: "collStats", Value: broadcastCollection}}, &stats)
+	if _, missing := err.(*mgo.QueryError); err != nil && !missing {
+		session.Close()
+		return nil, err
+	}
+
+	if !stats.Capped {
+		session.Close()
+		return nil, ErrBroadcastNotCapped
+	}
+
+	latest := broadcastMessage{}
+	err = collection.Find(nil).Sort("-$natural").One(&latest)
+	if err != nil && err != mgo.ErrNotFound {
+		session.Close()
+		return nil, err
+	}
+
+	adaptor := &MongoAdaptor{
+		local:      &socketRooms{rooms: make(map[string]map[string]socketio.Socket)},
+		session:    session,
+		collection: collection,
+		origin:     bson.NewObjectId().Hex(),
+		timeout:    timeout,
+		closed:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	go adaptor.tail(latest.ID)
+
+	return adaptor, nil
+}
+
+//Join lets the socket join the room
+func (a *MongoAdaptor) Join(room string, socket socketio.Socket) error {
+	a.local.join(room, socket)
+	return nil
+}
+
+//Leave lets the socket leave the room
+func (a *MongoAdaptor) Leave(room string, socket socketio.Socket) error {
+	a.local.leave(room, socket)
+	return nil
+}
+
+//Send emits the message to the sockets of this instance and stores it
+//for all other instances, ignore is not sent to if it is not nil
+func (a *MongoAdaptor) Send(ignore socketio.Socket, room, message string, args ...interface{}) error {
+	ignoreID := ""
+	if ignore != nil {
+		ignoreID = ignore.Id()
+	}
+
+	a.local.send(ignoreID, room, message, args...)
+
+	encoded := make([]string, 0, len(args))
+	for _, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return err
+		}
+
+		encoded = append(encoded, string(data))
+	}
+
+	return a.collection.Insert(broadcastMessage{
+		ID:      bson.NewObjectId(),
+		Origin:  a.origin,
+		Room:    room,
+		Message: message,
+		Ignore:  ignoreID,
+		Args:    encoded,
+		Created: time.Now(),
+	})
+}
+
+//deliver emits a message of another instance to the sockets of this one
+func (a *MongoAdaptor) deliver(message broadcastMessage) {
+	args := make([]interface{}, 0, len(message.Args))
+	for _, arg := range message.Args {
+		args = append(args, rawJSON(arg))
+	}
+
+	a.local.send(message.Ignore, message.Room, message.Message, args...)
+}
+
+//isClosed returns true once Close was called
+func (a *MongoAdaptor) isClosed() bool {
+	select {
+	case <-a.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+//tail delivers the messages of other instances that were inserted after
+//last until the adaptor is closed. A cursor that died is opened again
+//after a pause.
+func (a *MongoAdaptor) tail(last bson.ObjectId) {
+	defer close(a.done)
+
+	for !a.isClosed() {
+		query := bson.M{}
+		if last != "" {
+			query = bson.M{"_id": bson.M{"$gt": last}}
+		}
+
+		iter := a.collection.Find(query).Sort("$natural").Tail(a.timeout)
+		message := broadcastMessage{}
+		for !a.isClosed() {
+			for iter.Next(&message) {
+				last = message.ID
+				if message.Origin != a.origin {
+					a.deliver(message)
+				}
+
+				message = broadcastMessage{}
+			}
+
+			if iter.Err() != nil || !iter.Timeout() {
+				break
+			}
+		}
+
+		if err := iter.Close(); err != nil && !a.isClosed() {
+			log.Printf("broadcast cursor failed: %s\n", err)
+		}
+
+		//the cursor of an empty collection dies right away
+		select {
+		case <-a.closed:
+		case <-time.After(a.timeout):
+		}
+	}
+}
+
+//Close stops tailing the collection and releases the session
+func (a *MongoAdaptor) Close() {
+	close(a.closed)
+	<-a.done
+	a.session.Close()
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//emitRecorder is a socket that remembers the json of every emitted message
+type emitRecorder struct {
+	socketio.Socket
+	sync.Mutex
+	id       string
+	messages []string
+}
+
+func (e *emitRecorder) Id() string {
+	return e.id
+}
+
+func (e *emitRecorder) Emit(message string, args ...interface{}) error {
+	data, err := json.Marshal(append([]interface{}{message}, args...))
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+	e.messages = append(e.messages, string(data))
+	return nil
+}
+
+func (e *emitRecorder) received() []string {
+	e.Lock()
+	defer e.Unlock()
+
+	return append([]string{}, e.messages...)
+}
+
+var _ = Describe("Adaptor", func() {
+	It("Should emit arguments of other instances unchanged", func() {
+		data, err := json.Marshal([]interface{}{"presence", rawJSON(`[{"socketId":"a"}]`)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal(`["presence",[{"socketId":"a"}]]`))
+	})
+
+	It("Should not send to the ignored socket or other rooms", func() {
+		rooms := &socketRooms{rooms: make(map[string]map[string]socketio.Socket)}
+		first, second, other := &emitRecorder{id: "1"}, &emitRecorder{id: "2"}, &emitRecorder{id: "3"}
+		rooms.join("game:a", first)
+		rooms.join("game:a", second)
+		rooms.join("game:b", other)
+
+		rooms.send("1", "game:a", "player-joined", "1")
+		Expect(first.received()).To(BeEmpty())
+		Expect(second.received()).To(Equal([]string{`["player-joined","1"]`}))
+		Expect(other.received()).To(BeEmpty())
+
+		rooms.leave("game:a", second)
+		rooms.send("", "game:a", "presence")
+		Expect(second.received()).To(HaveLen(1))
+		Expect(rooms.rooms).To(HaveLen(2))
+	})
+
+	Context("starting without migrations", func() {
+		It("Should refuse a broadcast collection that is not capped", func() {
+			connection, err := bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+			defer connection.Session.DB(testDatabase).DropDatabase()
+
+			_, err = newMongoAdaptor(connection, 100*time.Millisecond)
+			Expect(err).To(Equal(ErrBroadcastNotCapped))
+
+			Expect(connection.Session.DB(testDatabase).C(broadcastCollection).Insert(broadcastMessage{ID: bson.NewObjectId()})).To(Succeed())
+			_, err = newMongoAdaptor(connection, 100*time.Millisecond)
+			Expect(err).To(Equal(ErrBroadcastNotCapped))
+		})
+	})
+
+	Context("sharing rooms between two servers", func() {
+		var (
+			connection    *bongo.Connection
+			first, second *MongoAdaptor
+		)
+
+		BeforeEach(func() {
+			first, second = nil, nil
+
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			runner := MigrationRunner(connection)
+			Expect(runner.Up()).To(Succeed())
+
+			first, err = newMongoAdaptor(connection, 100*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			second, err = newMongoAdaptor(connection, 100*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should deliver room messages to the sockets of both servers once", func() {
+			host, guest, stranger := &emitRecorder{id: "host"}, &emitRecorder{id: "guest"}, &emitRecorder{id: "stranger"}
+			Expect(first.Join("game:a", host)).To(Succeed())
+			Expect(second.Join("game:a", guest)).To(Succeed())
+			Expect(second.Join("game:b", stranger)).To(Succeed())
+
+			Expect(first.Send(nil, "game:a", "vote-result", roomMember{SocketID: "host", UserID: "u1"})).To(Succeed())
+			Expect(second.Send(guest, "game:a", "player-joined", "guest")).To(Succeed())
+
+			Expect(host.received()).To(ContainElement(`["vote-result",{"socketId":"host","userId":"u1"}]`))
+			Eventually(guest.received, 5*time.Second).Should(ContainElement(`["vote-result",{"socketId":"host","userId":"u1"}]`))
+			Eventually(host.received, 5*time.Second).Should(ContainElement(`["player-joined","guest"]`))
+
+			Consistently(host.received, 300*time.Millisecond).Should(HaveLen(2))
+			Expect(guest.received()).To(HaveLen(1))
+			Expect(stranger.received()).To(BeEmpty())
+		})
+
+		It("Should only deliver messages sent after the start", func() {
+			Expect(first.Send(nil, "game:a", "presence", "before")).To(Succeed())
+
+			late, err := newMongoAdaptor(connection, 100*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			defer late.Close()
+
+			guest := &emitRecorder{id: "guest"}
+			Expect(late.Join("game:a", guest)).To(Succeed())
+			Expect(first.Send(nil, "game:a", "presence", "after")).To(Succeed())
+
+			Eventually(guest.received, 5*time.Second).Should(Equal([]string{`["presence","after"]`}))
+		})
+
+		AfterEach(func() {
+			if first != nil {
+				first.Close()
+			}
+
+			if second != nil {
+				second.Close()
+			}
+
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//DemoUsers are created by the seed command
+var DemoUsers = []string{"anna", "ben", "carl", "dora"}
+
+//ListUsers returns all users ordered by username
+func ListUsers(connection *bongo.Connection) ([]User, error) {
+	users := []User{}
+	err := connection.Collection("user").Collection().Find(bson.M{}).Sort("username").All(&users)
+
+	return users, err
+}
+
+//findUserByName returns the user with that username
+func findUserByName(connection *bongo.Connection, username string) (User, error) {
+	user := User{}
+	err := connection.Collection("user").FindOne(bson.M{"username": username}, &user)
+	if _, ok := err.(*bongo.DocumentNotFoundError); ok {
+		return user, fmt.Errorf("there is no user %s", username)
+	}
+
+	return user, err
+}
+
+//adminSaveError describes why a user could not be saved
+func adminSaveError(err error, username string) error {
+	if mgo.IsDup(err) {
+		return fmt.Errorf("the username %s is already taken", username)
+	}
+
+	if validation, ok := err.(*bongo.ValidationError); ok && len(validation.Errors) > 0 {
+		return validation.Errors[0]
+	}
+
+	return err
+}
+
+//CreateUser creates a registered user with a password
+func CreateUser(connection *bongo.Connection, username, nickname, password string) (User, error) {
+	user := User{Username: username, Nickname: nickname}
+	if msg := checkCredentials(credentials{Username: username, Password: password}); msg != "" {
+		return user, errors.New(msg)
+	}
+
+	if err := user.SetPassword(password); err != nil {
+		return user, err
+	}
+
+	err := connection.Collection("user").Save(&user)
+
+	return user, adminSaveError(err, username)
+}
+
+//SetUserPassword replaces the password of a registered user, guests
+//have no username to log in with and claim their account themselves
+func SetUserPassword(connection *bongo.Connection, username, password string) error {
+	if len(password) < minPasswordLength {
+		return errors.New("The password is too short")
+	}
+
+	user, err := findUserByName(connection, username)
+	if err != nil {
+		return err
+	}
+
+	if user.Guest {
+		return fmt.Errorf("%s is a guest, guests claim their account in the app", username)
+	}
+
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+
+	return adminSaveError(connection.Collection("user").Save(&user), username)
+}
+
+//SetUserAdmin lets a registered user change every deck or takes it back
+func SetUserAdmin(connection *bongo.Connection, username string, admin bool) error {
+	user, err := findUserByName(connection, username)
+	if err != nil {
+		return err
+	}
+
+	if user.Guest {
+		return fmt.Errorf("%s is a guest, only registered users can be admins", username)
+	}
+
+	user.Admin = admin
+	return adminSaveError(connection.Collection("user").Save(&user), username)
+}
+
+//DeleteUser removes a user
+func DeleteUser(connection *bongo.Connection, username string) error {
+	user, err := findUserByName(connection, username)
+	if err != nil {
+		return err
+	}
+
+	return connection.Collection("user").DeleteDocument(&user)
+}
+
+//SeedUsers creates the demo users that do not exist yet, they all
+//share the same password
+func SeedUsers(connection *bongo.Connection, password string) ([]User, error) {
+	var created []User
+	for _, username := range DemoUsers {
+		count, err := connection.Collection("user").Collection().Find(bson.M{"username": username}).Count()
+		if err != nil {
+			return created, err
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		user, err := CreateUser(connection, username, username, password)
+		if err != nil {
+			return created, err
+		}
+
+		created = append(created, user)
+	}
+
+	return created, nil
+}
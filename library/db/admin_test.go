@@ -0,0 +1,64 @@
+package db
+
+import (
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin", func() {
+	It("Should refuse weak credentials before touching the database", func() {
+		_, err := CreateUser(nil, "unittest", "", "short")
+		Expect(err).To(MatchError("The password is too short"))
+
+		_, err = CreateUser(nil, guestUsernamePrefix+"unittest", "", "long enough")
+		Expect(err).To(HaveOccurred())
+		Expect(SetUserPassword(nil, "unittest", "short")).ToNot(Succeed())
+	})
+
+	Context("managing users", func() {
+		var connection *bongo.Connection
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should create, list and delete users", func() {
+			_, err := CreateUser(connection, "unittest", "Unit", "secret123")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(SetUserPassword(connection, "unittest", "secret456")).To(Succeed())
+			users, err := ListUsers(connection)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(users).To(HaveLen(1))
+			Expect(users[0].CheckPassword("secret456")).To(BeTrue())
+
+			Expect(DeleteUser(connection, "unittest")).To(Succeed())
+			Expect(DeleteUser(connection, "unittest")).To(MatchError("there is no user unittest"))
+		})
+
+		It("Should not set the password of guests", func() {
+			guest, _, err := newGuest("Partyhat")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connection.Collection("user").Save(&guest)).To(Succeed())
+
+			Expect(SetUserPassword(connection, guest.Username, "secret456")).To(HaveOccurred())
+		})
+
+		It("Should only seed missing demo users", func() {
+			created, err := SeedUsers(connection, "secret123")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(HaveLen(len(DemoUsers)))
+
+			created, err = SeedUsers(connection, "secret123")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(BeEmpty())
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
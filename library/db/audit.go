@@ -0,0 +1,101 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//auditPageSize is the number of audit entries returned at once
+const auditPageSize = 100
+
+//ModerationAction is something the host did to a game or a player
+type ModerationAction string
+
+const (
+	//ModerationKick removes a player from the game room
+	ModerationKick ModerationAction = "kick"
+	//ModerationBan removes a player and keeps them from joining again
+	ModerationBan ModerationAction = "ban"
+	//ModerationMute hides the chat messages of a player
+	ModerationMute ModerationAction = "mute"
+	//ModerationUnmute shows the chat messages of a player again
+	ModerationUnmute ModerationAction = "unmute"
+	//ModerationSkip ends the current turn
+	ModerationSkip ModerationAction = "skip"
+	//ModerationPause stops the clock of the game
+	ModerationPause ModerationAction = "pause"
+	//ModerationResume continues a paused game
+	ModerationResume ModerationAction = "resume"
+	//ModerationTransfer makes another player the host
+	ModerationTransfer ModerationAction = "transfer"
+)
+
+//AuditEntry records one moderation action of a host
+type AuditEntry struct {
+	ID       bson.ObjectId    `bson:"_id" json:"id"`
+	GameID   bson.ObjectId    `json:"gameId"`
+	ActorID  bson.ObjectId    `json:"actorId"`
+	Action   ModerationAction `json:"action"`
+	TargetID bson.ObjectId    `bson:",omitempty" json:"targetId,omitempty"`
+	Created  time.Time        `json:"created"`
+	exists   bool
+}
+
+//SetIsNew satisfies the document base
+func (a *AuditEntry) SetIsNew(isNew bool) {
+	a.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (a AuditEntry) IsNew() bool {
+	return !a.exists
+}
+
+//GetId Satisfy the document interface
+func (a AuditEntry) GetId() bson.ObjectId {
+	return a.ID
+}
+
+//SetId satisfy the document interface
+func (a *AuditEntry) SetId(id bson.ObjectId) {
+	a.ID = id
+}
+
+//auditLog stores the moderation actions of all games
+type auditLog struct {
+	connection *bongo.Connection
+}
+
+//record stores an action, a failed write is only logged because
+//the action already happened
+func (a auditLog) record(gameID, actorID bson.ObjectId, action ModerationAction, targetID bson.ObjectId) AuditEntry {
+	entry := AuditEntry{
+		ID:       bson.NewObjectId(),
+		GameID:   gameID,
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		Created:  time.Now(),
+	}
+
+	if err := a.connection.Collection("auditEntry").Save(&entry); err != nil {
+		log.Printf("could not audit %s in game %s: %s\n", action, gameID.Hex(), err)
+	}
+
+	return entry
+}
+
+//entries returns the latest actions of a game, newest first
+func (a auditLog) entries(gameID bson.ObjectId) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := a.connection.Collection("auditEntry").Collection().
+		Find(bson.M{"gameid": gameID}).
+		Sort("-created").
+		Limit(auditPageSize).
+		All(&entries)
+
+	return entries, err
+}
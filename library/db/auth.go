@@ -0,0 +1,163 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manyminds/api2go"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//DefaultTokenLifetime is how long a session token stays valid
+const DefaultTokenLifetime = 7 * 24 * time.Hour
+
+var (
+	//ErrMissingToken is returned when a request carries no session token
+	ErrMissingToken = errors.New("missing session token")
+	//ErrInvalidToken is returned for tokens that were not signed by us
+	ErrInvalidToken = errors.New("invalid session token")
+	//ErrExpiredToken is returned for tokens past their lifetime
+	ErrExpiredToken = errors.New("session token expired")
+)
+
+//Authenticator signs and verifies session tokens
+type Authenticator struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+//NewAuthenticator returns an authenticator signing tokens with secret.
+//Without secret a random one is used, then tokens only survive until restart.
+func NewAuthenticator(secret string, lifetime time.Duration) *Authenticator {
+	key := []byte(secret)
+	if secret == "" {
+		log.Println("No session secret configured, sessions will not survive a restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	return &Authenticator{secret: key, lifetime: lifetime}
+}
+
+//signature returns the url safe hmac of payload
+func (a *Authenticator) signature(payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(payload))
+
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//Sign returns a session token for the user and its expiry date,
+//the token has the format userID.expiry.signature
+func (a *Authenticator) Sign(userID bson.ObjectId) (string, time.Time) {
+	expires := time.Now().Add(a.lifetime)
+	payload := userID.Hex() + "." + strconv.FormatInt(expires.Unix(), 10)
+
+	return payload + "." + a.signature(payload), expires
+}
+
+//Verify checks the token and returns the id of the user it was issued for
+func (a *Authenticator) Verify(token string) (bson.ObjectId, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || !bson.IsObjectIdHex(parts[0]) {
+		return "", ErrInvalidToken
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(a.signature(payload))) {
+		return "", ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if time.Now().Unix() > expires {
+		return "", ErrExpiredToken
+	}
+
+	return bson.ObjectIdHex(parts[0]), nil
+}
+
+//Authenticate reads the token from the Authorization header
+//or the token query parameter used by the socket.io handshake
+func (a *Authenticator) Authenticate(r *http.Request) (bson.ObjectId, error) {
+	token := ""
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	} else {
+		token = r.URL.Query().Get("token")
+	}
+
+	if token == "" {
+		return "", ErrMissingToken
+	}
+
+	return a.Verify(token)
+}
+
+//Guard requires a valid session token for all requests that change data.
+//For every owned path like /v1/users/ the id following it must be the
+//id of the authenticated user, so nobody can change other users.
+func (a *Authenticator) Guard(handler http.Handler, ownedPaths ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := a.Authenticate(r)
+		if err != nil {
+			writeError(w, api2go.NewHTTPError(err, err.Error(), http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		for _, owned := range ownedPaths {
+			if !strings.HasPrefix(r.URL.Path, owned) {
+				continue
+			}
+
+			id := strings.SplitN(strings.TrimPrefix(r.URL.Path, owned), "/", 2)[0]
+			if id != userID.Hex() {
+				writeError(w, api2go.NewHTTPError(nil, "You can only change your own account", http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+//writeError responds with a json api error document
+func writeError(w http.ResponseWriter, err error, status int) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	w.Write([]byte(api2go.JSONContentMarshaler{}.MarshalError(err)))
+}
+
+//requestUser authenticates the plain request behind an api2go request,
+//sources use it to find out who is changing their data
+func (a *Authenticator) requestUser(r api2go.Request) (bson.ObjectId, error) {
+	if r.PlainRequest == nil {
+		return "", apiError(ErrMissingToken, http.StatusUnauthorized, ErrMissingToken.Error(), "")
+	}
+
+	userID, err := a.Authenticate(r.PlainRequest)
+	if err != nil {
+		return "", apiError(err, http.StatusUnauthorized, err.Error(), "")
+	}
+
+	return userID, nil
+}
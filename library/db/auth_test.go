@@ -0,0 +1,133 @@
+package db
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/manyminds/api2go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//sessionRequest returns an api2go request carrying a session token of userID
+func sessionRequest(auth *Authenticator, userID bson.ObjectId) api2go.Request {
+	token, _ := auth.Sign(userID)
+	r, _ := http.NewRequest("POST", "/v1", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return api2go.Request{PlainRequest: r}
+}
+
+var _ = Describe("Auth", func() {
+	var auth *Authenticator
+	var userID bson.ObjectId
+
+	BeforeEach(func() {
+		auth = NewAuthenticator("secret", DefaultTokenLifetime)
+		userID = bson.NewObjectId()
+	})
+
+	Context("passwords", func() {
+		It("Should only accept the password that was set", func() {
+			user := User{Username: "Unittest"}
+			Expect(user.SetPassword("correct horse")).To(Succeed())
+			Expect(user.PasswordHash).ToNot(Equal("correct horse"))
+			Expect(user.CheckPassword("correct horse")).To(BeTrue())
+			Expect(user.CheckPassword("battery staple")).To(BeFalse())
+		})
+
+		It("Should not accept any password without hash", func() {
+			user := User{Username: "Unittest"}
+			Expect(user.CheckPassword("")).To(BeFalse())
+		})
+	})
+
+	Context("session tokens", func() {
+		It("Should verify its own tokens", func() {
+			token, expires := auth.Sign(userID)
+			Expect(expires).To(BeTemporally(">", time.Now()))
+
+			verified, err := auth.Verify(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verified).To(Equal(userID))
+		})
+
+		It("Should refuse tampered tokens", func() {
+			token, _ := auth.Sign(userID)
+			parts := strings.Split(token, ".")
+			parts[0] = bson.NewObjectId().Hex()
+
+			_, err := auth.Verify(strings.Join(parts, "."))
+			Expect(err).To(Equal(ErrInvalidToken))
+		})
+
+		It("Should refuse tokens of another secret", func() {
+			token, _ := NewAuthenticator("other", DefaultTokenLifetime).Sign(userID)
+			_, err := auth.Verify(token)
+			Expect(err).To(Equal(ErrInvalidToken))
+		})
+
+		It("Should refuse expired tokens", func() {
+			token, _ := NewAuthenticator("secret", -time.Minute).Sign(userID)
+			_, err := auth.Verify(token)
+			Expect(err).To(Equal(ErrExpiredToken))
+		})
+
+		It("Should read the token from the query for socket handshakes", func() {
+			token, _ := auth.Sign(userID)
+			r, _ := http.NewRequest("GET", "/socket.io/?token="+token, nil)
+
+			verified, err := auth.Authenticate(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verified).To(Equal(userID))
+		})
+
+		It("Should find the user of api requests", func() {
+			verified, err := auth.requestUser(sessionRequest(auth, userID))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(verified).To(Equal(userID))
+
+			_, err = auth.requestUser(api2go.Request{})
+			Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("guard", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			handler = auth.Guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), "/v1/users/")
+		})
+
+		serve := func(method, path, token string) int {
+			r, _ := http.NewRequest(method, path, nil)
+			if token != "" {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		It("Should let everybody read", func() {
+			Expect(serve("GET", "/v1/users", "")).To(Equal(http.StatusOK))
+		})
+
+		It("Should require a token for changes", func() {
+			Expect(serve("POST", "/v1/games", "")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should only let users change themselves", func() {
+			token, _ := auth.Sign(userID)
+			Expect(serve("PATCH", "/v1/users/"+userID.Hex(), token)).To(Equal(http.StatusOK))
+			Expect(serve("DELETE", "/v1/users/"+bson.NewObjectId().Hex(), token)).To(Equal(http.StatusForbidden))
+			Expect(serve("POST", "/v1/games", token)).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+//DefaultGracePeriod is how long a player that lost the connection
+//keeps the seat in a game
+const DefaultGracePeriod = time.Minute
+
+//awaySeat is the seat of a player whose last socket disconnected
+type awaySeat struct {
+	room  string
+	until time.Time
+	timer *time.Timer
+}
+
+//awayPlayers keeps the seats of players that lost their connection
+//until they come back or the grace period is over. A user can only
+//be away from one game at a time.
+type awayPlayers struct {
+	sync.Mutex
+	grace time.Duration
+	seats map[string]*awaySeat
+}
+
+func newAwayPlayers(grace time.Duration) *awayPlayers {
+	return &awayPlayers{grace: grace, seats: make(map[string]*awaySeat)}
+}
+
+//away keeps the seat of the user in the room, expire is called if the
+//user does not come back in time. It returns the end of the grace period.
+func (a *awayPlayers) away(room, userID string, expire func()) time.Time {
+	a.Lock()
+	defer a.Unlock()
+
+	if previous, ok := a.seats[userID]; ok {
+		previous.timer.Stop()
+	}
+
+	seat := &awaySeat{room: room, until: time.Now().Add(a.grace)}
+	seat.timer = time.AfterFunc(a.grace, func() {
+		a.Lock()
+		current, ok := a.seats[userID]
+		if !ok || current != seat {
+			a.Unlock()
+			return
+		}
+
+		delete(a.seats, userID)
+		a.Unlock()
+		expire()
+	})
+	a.seats[userID] = seat
+
+	return seat.until
+}
+
+//back returns true if the user was away from the room and gets the seat back
+func (a *awayPlayers) back(room, userID string) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	seat, ok := a.seats[userID]
+	if !ok || seat.room != room {
+		return false
+	}
+
+	seat.timer.Stop()
+	delete(a.seats, userID)
+
+	return true
+}
+
+//roomOf returns the room the user is away from, if any
+func (a *awayPlayers) roomOf(userID string) string {
+	a.Lock()
+	defer a.Unlock()
+
+	if seat, ok := a.seats[userID]; ok {
+		return seat.room
+	}
+
+	return ""
+}
+
+//stop forgets all seats without expiring them
+func (a *awayPlayers) stop() {
+	a.Lock()
+	defer a.Unlock()
+
+	for userID, seat := range a.seats {
+		seat.timer.Stop()
+		delete(a.seats, userID)
+	}
+}
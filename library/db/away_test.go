@@ -0,0 +1,45 @@
+package db
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Away", func() {
+	It("Should give the seat back within the grace period", func() {
+		away := newAwayPlayers(time.Minute)
+		expired := false
+		until := away.away("game:a", "anna", func() { expired = true })
+
+		Expect(until).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+		Expect(away.roomOf("anna")).To(Equal("game:a"))
+		Expect(away.back("game:b", "anna")).To(BeFalse())
+		Expect(away.back("game:a", "anna")).To(BeTrue())
+		Expect(away.back("game:a", "anna")).To(BeFalse())
+		Expect(away.roomOf("anna")).To(Equal(""))
+		Expect(expired).To(BeFalse())
+	})
+
+	It("Should expire players that do not come back", func() {
+		away := newAwayPlayers(10 * time.Millisecond)
+		expired := make(chan string, 2)
+		away.away("game:a", "anna", func() { expired <- "game:a" })
+		away.away("game:b", "anna", func() { expired <- "game:b" })
+
+		Eventually(expired).Should(Receive(Equal("game:b")))
+		Consistently(expired, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(away.roomOf("anna")).To(Equal(""))
+	})
+
+	It("Should forget all seats on stop", func() {
+		away := newAwayPlayers(10 * time.Millisecond)
+		expired := make(chan string, 1)
+		away.away("game:a", "anna", func() { expired <- "game:a" })
+		away.stop()
+
+		Consistently(expired, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(away.seats).To(BeEmpty())
+	})
+})
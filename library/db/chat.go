@@ -0,0 +1,287 @@
+package db
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//maxChatLength is the number of characters of one chat message
+	maxChatLength = 500
+	//chatHistorySize is the number of messages a joining socket gets
+	chatHistorySize = 50
+	//chatBurst is the number of messages a socket can send per chatWindow
+	chatBurst = 5
+	//chatWindow is the time chatBurst messages are counted in
+	chatWindow = 10 * time.Second
+)
+
+//ChatMessage is written by a player or by the server into the chat of
+//a game, system messages have no sender id
+type ChatMessage struct {
+	ID       bson.ObjectId `bson:"_id" json:"id"`
+	GameID   bson.ObjectId `json:"gameId"`
+	SenderID bson.ObjectId `bson:",omitempty" json:"senderId,omitempty"`
+	Sender   string        `json:"sender"`
+	Text     string        `json:"text"`
+	System   bool          `json:"system"`
+	Created  time.Time     `json:"created"`
+	exists   bool
+}
+
+//SetIsNew satisfies the document base
+func (c *ChatMessage) SetIsNew(isNew bool) {
+	c.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (c ChatMessage) IsNew() bool {
+	return !c.exists
+}
+
+//GetId Satisfy the document interface
+func (c ChatMessage) GetId() bson.ObjectId {
+	return c.ID
+}
+
+//SetId satisfy the document interface
+func (c *ChatMessage) SetId(id bson.ObjectId) {
+	c.ID = id
+}
+
+//wordFilter stars out configured words, only whole words are
+//matched and case does not matter
+type wordFilter map[string]bool
+
+func newWordFilter(words []string) wordFilter {
+	filter := wordFilter{}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			filter[word] = true
+		}
+	}
+
+	return filter
+}
+
+//clean returns the text with every filtered word replaced by stars
+func (f wordFilter) clean(text string) string {
+	if len(f) == 0 {
+		return text
+	}
+
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	out := make([]rune, 0, len(text))
+	rest := text
+	for rest != "" {
+		end := strings.IndexFunc(rest, isSeparator)
+		if end == 0 {
+			r, size := utf8.DecodeRuneInString(rest)
+			out = append(out, r)
+			rest = rest[size:]
+			continue
+		}
+
+		if end < 0 {
+			end = len(rest)
+		}
+
+		word := rest[:end]
+		if f[strings.ToLower(word)] {
+			word = strings.Repeat("*", utf8.RuneCountInString(word))
+		}
+
+		out = append(out, []rune(word)...)
+		rest = rest[end:]
+	}
+
+	return string(out)
+}
+
+//chatLimiter allows every socket burst messages per window
+type chatLimiter struct {
+	sync.Mutex
+	burst  int
+	window time.Duration
+	sent   map[string][]time.Time
+}
+
+func newChatLimiter(burst int, window time.Duration) *chatLimiter {
+	return &chatLimiter{burst: burst, window: window, sent: make(map[string][]time.Time)}
+}
+
+//allow counts a message of the socket if it is within the limit
+func (l *chatLimiter) allow(socketID string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	recent := l.sent[socketID][:0]
+	for _, sent := range l.sent[socketID] {
+		if now.Sub(sent) < l.window {
+			recent = append(recent, sent)
+		}
+	}
+
+	if len(recent) >= l.burst {
+		l.sent[socketID] = recent
+		return false
+	}
+
+	l.sent[socketID] = append(recent, now)
+	return true
+}
+
+//forget drops the counter of a socket that disconnected
+func (l *chatLimiter) forget(socketID string) {
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.sent, socketID)
+}
+
+//chatRoom stores the chat of every game and sends it to its room
+type chatRoom struct {
+	connection *bongo.Connection
+	server     broadcaster
+	registry   *roomRegistry
+	filter     wordFilter
+	limiter    *chatLimiter
+}
+
+func newChatRoom(server broadcaster, connection *bongo.Connection, registry *roomRegistry, words []string) *chatRoom {
+	return &chatRoom{
+		connection: connection,
+		server:     server,
+		registry:   registry,
+		filter:     newWordFilter(words),
+		limiter:    newChatLimiter(chatBurst, chatWindow),
+	}
+}
+
+//name returns the nickname of a user or the username if there is none
+func (c *chatRoom) name(userID bson.ObjectId) string {
+	user := User{}
+	if err := c.connection.Collection("user").FindById(userID, &user); err != nil {
+		return "A player"
+	}
+
+	if user.Nickname != "" {
+		return user.Nickname
+	}
+
+	return user.Username
+}
+
+//store saves the message and sends it to the game room
+func (c *chatRoom) store(room string, message ChatMessage) error {
+	message.ID = bson.NewObjectId()
+	message.GameID = roomGameID(room)
+	message.Created = time.Now()
+	if err := c.connection.Collection("chatMessage").Save(&message); err != nil {
+		return err
+	}
+
+	c.server.BroadcastTo(room, "chat message", message)
+
+	return nil
+}
+
+//send is called for the chat message event of a socket, muted players
+//can not write
+func (c *chatRoom) send(so socketio.Socket, text string) {
+	member, room, ok := c.registry.memberOf(so.Id())
+	if !ok || !bson.IsObjectIdHex(member.UserID) {
+		so.Emit("chat-failed", "Join a game first")
+		return
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		so.Emit("chat-failed", "Write a message first")
+		return
+	}
+
+	if utf8.RuneCountInString(text) > maxChatLength {
+		so.Emit("chat-failed", "The message is too long")
+		return
+	}
+
+	if !c.limiter.allow(so.Id(), time.Now()) {
+		so.Emit("chat-failed", "You are sending messages too fast")
+		return
+	}
+
+	userID := bson.ObjectIdHex(member.UserID)
+	game := Game{}
+	if err := c.connection.Collection("game").FindById(roomGameID(room), &game); err != nil {
+		log.Printf("could not load the game of %s: %s\n", room, err)
+		so.Emit("chat-failed", "Could not send the message")
+		return
+	}
+
+	if game.IsMuted(userID) {
+		so.Emit("chat-failed", "You were muted by the host")
+		return
+	}
+
+	message := ChatMessage{SenderID: userID, Sender: c.name(userID), Text: c.filter.clean(text)}
+	if err := c.store(room, message); err != nil {
+		log.Printf("could not store a chat message in %s: %s\n", room, err)
+		so.Emit("chat-failed", "Could not send the message")
+	}
+}
+
+//system writes a message of the server into the chat of the room
+func (c *chatRoom) system(room, text string) {
+	if err := c.store(room, ChatMessage{System: true, Text: text}); err != nil {
+		log.Printf("could not store a chat message in %s: %s\n", room, err)
+	}
+}
+
+//disconnected tells the room that a player lost the connection
+func (c *chatRoom) disconnected(room, userID string) {
+	name := "A player"
+	if bson.IsObjectIdHex(userID) {
+		name = c.name(bson.ObjectIdHex(userID))
+	}
+
+	c.system(room, name+" lost the connection")
+}
+
+//messages returns the latest messages of a game, oldest first
+func (c *chatRoom) messages(gameID bson.ObjectId) ([]ChatMessage, error) {
+	messages := []ChatMessage{}
+	err := c.connection.Collection("chatMessage").Collection().
+		Find(bson.M{"gameid": gameID}).
+		Sort("-created", "-_id").
+		Limit(chatHistorySize).
+		All(&messages)
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, err
+}
+
+//history sends the latest messages of the room to a socket that joined
+func (c *chatRoom) history(so socketio.Socket, room string) {
+	messages, err := c.messages(roomGameID(room))
+	if err != nil {
+		log.Printf("could not load the chat of %s: %s\n", room, err)
+		return
+	}
+
+	so.Emit("chat-history", messages)
+}
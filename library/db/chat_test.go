@@ -0,0 +1,99 @@
+package db
+
+import (
+	"time"
+
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Chat", func() {
+	It("Should star out whole filtered words of any case", func() {
+		filter := newWordFilter([]string{"beer", " Wein "})
+		Expect(filter.clean("BEER, beers and wein!")).To(Equal("****, beers and ****!"))
+		Expect(filter.clean("Bier für alle")).To(Equal("Bier für alle"))
+		Expect(newWordFilter(nil).clean("beer")).To(Equal("beer"))
+	})
+
+	It("Should limit the messages of every socket", func() {
+		limiter := newChatLimiter(2, time.Minute)
+		now := time.Now()
+		Expect(limiter.allow("1", now)).To(BeTrue())
+		Expect(limiter.allow("1", now)).To(BeTrue())
+		Expect(limiter.allow("1", now)).To(BeFalse())
+		Expect(limiter.allow("2", now)).To(BeTrue())
+		Expect(limiter.allow("1", now.Add(time.Minute))).To(BeTrue())
+
+		limiter.forget("1")
+		Expect(limiter.sent).ToNot(HaveKey("1"))
+	})
+
+	It("Should refuse messages of sockets outside of a game", func() {
+		chat := newChatRoom(&roomRecorder{}, nil, newRoomRegistry(), nil)
+		so := &emitRecorder{id: "1"}
+		chat.send(so, "hello")
+
+		Expect(so.received()).To(Equal([]string{`["chat-failed","Join a game first"]`}))
+	})
+
+	Context("chatting in a game", func() {
+		var (
+			connection *bongo.Connection
+			server     *roomRecorder
+			registry   *roomRegistry
+			chat       *chatRoom
+			stored     Game
+			player     User
+			room       string
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			player = User{Username: "anna", Nickname: "Anna"}
+			Expect(connection.Collection("user").Save(&player)).To(Succeed())
+			stored = Game{HostID: bson.NewObjectId(), PlayerIDs: []bson.ObjectId{player.ID}, JoinCode: newJoinCode(2), State: GameStateLobby}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+
+			server = &roomRecorder{}
+			registry = newRoomRegistry()
+			chat = newChatRoom(server, connection, registry, []string{"beer"})
+			room = roomName(stored)
+			registry.join(room, roomMember{SocketID: "1", UserID: player.ID.Hex()})
+		})
+
+		It("Should store filtered messages and send them as history", func() {
+			so := &emitRecorder{id: "1"}
+			chat.send(so, "  more beer  ")
+			chat.disconnected(room, player.ID.Hex())
+			Expect(so.received()).To(BeEmpty())
+			Expect(server.messages).To(Equal([]string{"chat message", "chat message"}))
+
+			messages, err := chat.messages(stored.ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(messages).To(HaveLen(2))
+			Expect(messages[0].Text).To(Equal("more ****"))
+			Expect(messages[0].Sender).To(Equal("Anna"))
+			Expect(messages[1].System).To(BeTrue())
+			Expect(messages[1].Text).To(Equal("Anna lost the connection"))
+		})
+
+		It("Should not let muted players write", func() {
+			update := bson.M{"$addToSet": bson.M{"mutedids": player.ID}}
+			Expect(connection.Collection("game").Collection().UpdateId(stored.ID, update)).To(Succeed())
+
+			so := &emitRecorder{id: "1"}
+			chat.send(so, "hello")
+			Expect(so.received()).To(Equal([]string{`["chat-failed","You were muted by the host"]`}))
+			Expect(server.messages).To(BeEmpty())
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
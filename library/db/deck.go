@@ -0,0 +1,529 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/api2go/jsonapi"
+	"github.com/manyminds/soyfr/library/common"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//maxSips is the most a single challenge can ask for
+	maxSips = game.MaxSips
+	//maxTimer is the longest timer of a challenge in seconds
+	maxTimer = 3600
+	//maxChallengeText is the longest challenge text
+	maxChallengeText = 500
+	//maxPlayerCount is the largest player range a challenge can ask for
+	maxPlayerCount = 100
+)
+
+//Deck is a named set of challenges a game draws from. Only the user
+//that created it and admins can change it, imported decks have no owner.
+type Deck struct {
+	ID          bson.ObjectId `bson:"_id"`
+	OwnerID     bson.ObjectId `json:"-"`
+	Name        string
+	Description string
+	exists      bool
+}
+
+//SetIsNew satisfies the document base
+func (d *Deck) SetIsNew(isNew bool) {
+	d.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (d Deck) IsNew() bool {
+	return !d.exists
+}
+
+//GetId Satisfy the document interface
+func (d Deck) GetId() bson.ObjectId {
+	return d.ID
+}
+
+//SetId satisfy the document interface
+func (d *Deck) SetId(id bson.ObjectId) {
+	d.ID = id
+}
+
+//GetID to satisfy api2go interface
+func (d Deck) GetID() string {
+	return d.ID.Hex()
+}
+
+//SetID to satisfy api2go interface
+func (d *Deck) SetID(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("invalid id %s", id)
+	}
+
+	d.ID = bson.ObjectIdHex(id)
+	return nil
+}
+
+//Validate satisfies the bongo validate hook
+func (d Deck) Validate(c *bongo.Collection) []error {
+	return validate(
+		field("name", d.Name, required, maxLength(maxNameLength), printable),
+		field("description", d.Description, maxLength(maxChallengeText)),
+	)
+}
+
+//Challenge is a single card of a deck. A challenge is only drawn if the
+//number of players is between MinPlayers and MaxPlayers, zero means no limit.
+//Timer is in seconds, zero means the challenge has no timer.
+type Challenge struct {
+	ID         bson.ObjectId `bson:"_id"`
+	DeckID     bson.ObjectId `json:"-"`
+	Text       string
+	Category   string
+	Sips       int
+	MinPlayers int
+	MaxPlayers int
+	Timer      int
+	exists     bool
+}
+
+//SetIsNew satisfies the document base
+func (c *Challenge) SetIsNew(isNew bool) {
+	c.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (c Challenge) IsNew() bool {
+	return !c.exists
+}
+
+//GetId Satisfy the document interface
+func (c Challenge) GetId() bson.ObjectId {
+	return c.ID
+}
+
+//SetId satisfy the document interface
+func (c *Challenge) SetId(id bson.ObjectId) {
+	c.ID = id
+}
+
+//GetID to satisfy api2go interface
+func (c Challenge) GetID() string {
+	return c.ID.Hex()
+}
+
+//SetID to satisfy api2go interface
+func (c *Challenge) SetID(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("invalid id %s", id)
+	}
+
+	c.ID = bson.ObjectIdHex(id)
+	return nil
+}
+
+//GetReferences to satisfy the api2go relation interface
+func (c Challenge) GetReferences() []jsonapi.Reference {
+	return []jsonapi.Reference{{Type: "decks", Name: "deck"}}
+}
+
+//GetReferencedIDs to satisfy the api2go relation interface
+func (c Challenge) GetReferencedIDs() []jsonapi.ReferenceID {
+	if !c.DeckID.Valid() {
+		return nil
+	}
+
+	return []jsonapi.ReferenceID{{ID: c.DeckID.Hex(), Type: "decks", Name: "deck"}}
+}
+
+//SetToOneReferenceID to satisfy the api2go relation interface
+func (c *Challenge) SetToOneReferenceID(name, ID string) error {
+	if name != "deck" {
+		return fmt.Errorf("there is no to-one relationship with the name %s", name)
+	}
+
+	if !bson.IsObjectIdHex(ID) {
+		return fmt.Errorf("invalid deck id %s", ID)
+	}
+
+	c.DeckID = bson.ObjectIdHex(ID)
+	return nil
+}
+
+//Fits checks if the challenge can be played with that many players
+func (c Challenge) Fits(players int) bool {
+	return c.card().Fits(players)
+}
+
+//card returns the challenge as the turn engine draws it
+func (c Challenge) card() game.Challenge {
+	return game.Challenge{
+		ID:         c.ID.Hex(),
+		Text:       c.Text,
+		Category:   c.Category,
+		Sips:       c.Sips,
+		MinPlayers: c.MinPlayers,
+		MaxPlayers: c.MaxPlayers,
+		Timer:      c.Timer,
+	}
+}
+
+//Validate satisfies the bongo validate hook
+func (c Challenge) Validate(collection *bongo.Collection) []error {
+	errs := validate(
+		field("text", c.Text, required, maxLength(maxChallengeText), printable),
+		field("category", c.Category, required, maxLength(maxNameLength), printable),
+	)
+
+	if !c.DeckID.Valid() {
+		errs = append(errs, fieldError{Field: "deck", Msg: "A challenge needs a deck", Relationship: true})
+	}
+
+	numbers := []error{
+		between("sips", c.Sips, 0, maxSips),
+		between("minPlayers", c.MinPlayers, 0, maxPlayerCount),
+		between("maxPlayers", c.MaxPlayers, 0, maxPlayerCount),
+		between("timer", c.Timer, 0, maxTimer),
+	}
+
+	for _, err := range numbers {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.MaxPlayers > 0 && c.MinPlayers > c.MaxPlayers {
+		errs = append(errs, fieldError{Field: "maxPlayers", Msg: "Must not be less than minPlayers"})
+	}
+
+	return errs
+}
+
+//deckEditor returns the logged in user of the request, guests can
+//not change decks
+func deckEditor(connection *bongo.Connection, auth *Authenticator, r api2go.Request) (User, error) {
+	userID, err := auth.requestUser(r)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{}
+	if err := connection.Collection("user").FindById(userID, &user); err != nil {
+		return user, mapError(err)
+	}
+
+	if user.Guest {
+		return user, apiError(nil, http.StatusForbidden, "Guests can not change decks", "")
+	}
+
+	return user, nil
+}
+
+//ownedDeck returns the deck if the editor owns it or is an admin
+func ownedDeck(connection *bongo.Connection, deckID bson.ObjectId, editor User) (Deck, error) {
+	deck := Deck{}
+	if err := connection.Collection("deck").FindById(deckID, &deck); err != nil {
+		return deck, mapError(err)
+	}
+
+	if !editor.Admin && deck.OwnerID != editor.ID {
+		return deck, apiError(nil, http.StatusForbidden, "Only the owner can change this deck", "")
+	}
+
+	return deck, nil
+}
+
+//DeckSource for api2go, registered users create decks and only
+//change their own
+type DeckSource struct {
+	connection *bongo.Connection
+	auth       *Authenticator
+}
+
+//FindAll satisfies api2go data source interface
+func (s DeckSource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	decks := []Deck{}
+	deck := Deck{}
+	resultSet := s.connection.Collection("deck").Find(bson.M{})
+	if resultSet.Error != nil {
+		return &common.Response{}, mapError(resultSet.Error)
+	}
+
+	for resultSet.Next(&deck) {
+		decks = append(decks, deck)
+	}
+
+	return &common.Response{Res: decks, Code: http.StatusOK}, nil
+}
+
+//FindOne satisfies api2go data source interface
+func (s DeckSource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	id, err := parseID(ID)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	deck := Deck{}
+	err = s.connection.Collection("deck").FindById(id, &deck)
+
+	return &common.Response{Res: deck, Code: http.StatusOK}, mapError(err)
+}
+
+//Create satisfies api2go create interface, the logged in user owns the deck
+func (s DeckSource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	deck, ok := obj.(Deck)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	deck.ID = bson.NewObjectId()
+	deck.OwnerID = editor.ID
+	err = s.connection.Collection("deck").Save(&deck)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: deck, Code: http.StatusCreated}, nil
+}
+
+//Update satisfies api2go update interface, only the owner or an admin
+//can change the deck
+func (s DeckSource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	deck, ok := obj.(Deck)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	stored, err := ownedDeck(s.connection, deck.ID, editor)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	deck.OwnerID = stored.OwnerID
+	deck.SetIsNew(false)
+	err = s.connection.Collection("deck").Save(&deck)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: deck, Code: http.StatusOK}, nil
+}
+
+//Delete deletes the deck with all its challenges, only the owner or
+//an admin can delete it
+func (s DeckSource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
+	deckID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return nil, err
+	}
+
+	deck, err := ownedDeck(s.connection, deckID, editor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.connection.Collection("challenge").Collection().RemoveAll(bson.M{"deckid": deck.ID})
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	err = s.connection.Collection("deck").DeleteDocument(&deck)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &common.Response{Res: deck, Code: http.StatusOK}, nil
+}
+
+//challengeSortable are the fields challenges can be sorted by
+var challengeSortable = map[string]string{"category": "category", "sips": "sips"}
+
+//challengeFilterable are the fields challenges can be searched by prefix
+var challengeFilterable = map[string]string{"category": "category", "text": "text"}
+
+//ChallengeSource for api2go, challenges are changed by the owner
+//of their deck
+type ChallengeSource struct {
+	connection *bongo.Connection
+	auth       *Authenticator
+}
+
+//FindAll satisfies api2go data source interface
+func (s ChallengeSource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	_, response, err := s.PaginatedFindAll(r)
+	return response, err
+}
+
+//PaginatedFindAll satisfies api2go paging interface, filter[deck] only
+//returns the challenges of one deck
+func (s ChallengeSource) PaginatedFindAll(r api2go.Request) (uint, api2go.Responder, error) {
+	challenges := []Challenge{}
+	challenge := Challenge{}
+
+	query, err := parseListQuery(r, challengeSortable, challengeFilterable)
+	if err != nil {
+		return 0, &common.Response{}, err
+	}
+
+	if deck := queryParam(r, "filter[deck]"); deck != "" {
+		if !bson.IsObjectIdHex(deck) {
+			return 0, &common.Response{}, parameterError("filter[deck]", "Invalid deck id")
+		}
+
+		query.Filter["deckid"] = bson.ObjectIdHex(deck)
+	}
+
+	resultSet, total, err := query.find(s.connection.Collection("challenge"))
+	if err != nil {
+		return 0, &common.Response{}, mapError(err)
+	}
+
+	for resultSet.Next(&challenge) {
+		challenges = append(challenges, challenge)
+	}
+
+	if resultSet.Error != nil {
+		return 0, &common.Response{}, mapError(resultSet.Error)
+	}
+
+	meta := map[string]interface{}{"total": total}
+	if len(challenges) == query.Limit {
+		meta["next"] = challenges[len(challenges)-1].GetID()
+	}
+
+	return total, &common.Response{Res: challenges, Code: http.StatusOK, Meta: meta}, nil
+}
+
+//FindOne satisfies api2go data source interface
+func (s ChallengeSource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	id, err := parseID(ID)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	challenge := Challenge{}
+	err = s.connection.Collection("challenge").FindById(id, &challenge)
+
+	return &common.Response{Res: challenge, Code: http.StatusOK}, mapError(err)
+}
+
+//Create satisfies api2go create interface, challenges are only added
+//to decks of the logged in user
+func (s ChallengeSource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	challenge, ok := obj.(Challenge)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if errs := challenge.Validate(nil); len(errs) > 0 {
+		return &common.Response{}, saveError(&bongo.ValidationError{Errors: errs})
+	}
+
+	if _, err := ownedDeck(s.connection, challenge.DeckID, editor); err != nil {
+		return &common.Response{}, err
+	}
+
+	challenge.ID = bson.NewObjectId()
+	err = s.connection.Collection("challenge").Save(&challenge)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: challenge, Code: http.StatusCreated}, nil
+}
+
+//Update satisfies api2go update interface, a challenge can only be
+//moved between decks of the same owner
+func (s ChallengeSource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	challenge, ok := obj.(Challenge)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	stored, err := s.ownedChallenge(challenge.ID, editor)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if challenge.DeckID != stored.DeckID {
+		if _, err := ownedDeck(s.connection, challenge.DeckID, editor); err != nil {
+			return &common.Response{}, err
+		}
+	}
+
+	challenge.SetIsNew(false)
+	err = s.connection.Collection("challenge").Save(&challenge)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: challenge, Code: http.StatusOK}, nil
+}
+
+//Delete deletes the instance, only the owner of the deck or an admin
+//can delete it
+func (s ChallengeSource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
+	challengeID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, err := deckEditor(s.connection, s.auth, r)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.ownedChallenge(challengeID, editor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.connection.Collection("challenge").DeleteDocument(&challenge)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &common.Response{Res: challenge, Code: http.StatusOK}, nil
+}
+
+//ownedChallenge returns the challenge if the editor may change its deck
+func (s ChallengeSource) ownedChallenge(challengeID bson.ObjectId, editor User) (Challenge, error) {
+	challenge := Challenge{}
+	if err := s.connection.Collection("challenge").FindById(challengeID, &challenge); err != nil {
+		return challenge, mapError(err)
+	}
+
+	_, err := ownedDeck(s.connection, challenge.DeckID, editor)
+
+	return challenge, err
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/yaml.v2"
+)
+
+//DeckFile is the format decks are kept in, a file holds one deck
+//or a list of decks
+type DeckFile struct {
+	Name        string          `json:"name" yaml:"name"`
+	Description string          `json:"description" yaml:"description"`
+	Challenges  []ChallengeFile `json:"challenges" yaml:"challenges"`
+}
+
+//ChallengeFile is a challenge within a DeckFile
+type ChallengeFile struct {
+	Text       string `json:"text" yaml:"text"`
+	Category   string `json:"category" yaml:"category"`
+	Sips       int    `json:"sips" yaml:"sips"`
+	MinPlayers int    `json:"minPlayers" yaml:"minPlayers"`
+	MaxPlayers int    `json:"maxPlayers" yaml:"maxPlayers"`
+	Timer      int    `json:"timer" yaml:"timer"`
+}
+
+//unmarshalDecks decodes one deck or a list of decks with decode
+func unmarshalDecks(data []byte, decode func([]byte, interface{}) error) ([]DeckFile, error) {
+	var decks []DeckFile
+	if err := decode(data, &decks); err == nil {
+		return decks, nil
+	}
+
+	var deck DeckFile
+	if err := decode(data, &deck); err != nil {
+		return nil, err
+	}
+
+	return []DeckFile{deck}, nil
+}
+
+//ParseDecks reads decks from json or yaml, format is the file extension
+func ParseDecks(data []byte, format string) ([]DeckFile, error) {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "json":
+		return unmarshalDecks(data, json.Unmarshal)
+	case "yml", "yaml":
+		return unmarshalDecks(data, yaml.Unmarshal)
+	}
+
+	return nil, fmt.Errorf("unknown deck format %s, use json or yaml", format)
+}
+
+//ReadDeckFile loads all decks of a json or yaml file
+func ReadDeckFile(path string) ([]DeckFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDecks(data, filepath.Ext(path))
+}
+
+//challenges converts the file into challenge documents of the deck
+func (f DeckFile) challenges(deckID bson.ObjectId) []Challenge {
+	var challenges []Challenge
+	for _, c := range f.Challenges {
+		challenges = append(challenges, Challenge{
+			DeckID:     deckID,
+			Text:       c.Text,
+			Category:   c.Category,
+			Sips:       c.Sips,
+			MinPlayers: c.MinPlayers,
+			MaxPlayers: c.MaxPlayers,
+			Timer:      c.Timer,
+		})
+	}
+
+	return challenges
+}
+
+//CheckDecks validates all decks and challenges without storing them
+func CheckDecks(decks []DeckFile) error {
+	for _, file := range decks {
+		if errs := (Deck{Name: file.Name, Description: file.Description}).Validate(nil); len(errs) > 0 {
+			return fmt.Errorf("deck %q: %s", file.Name, errs[0])
+		}
+
+		//any valid id will do, the real one is known after saving the deck
+		for i, challenge := range file.challenges(bson.NewObjectId()) {
+			if errs := challenge.Validate(nil); len(errs) > 0 {
+				return fmt.Errorf("deck %q, challenge %d: %s", file.Name, i+1, errs[0])
+			}
+		}
+	}
+
+	return nil
+}
+
+//ImportDecks stores the decks, a deck with the same name is replaced
+//so the content can be versioned and imported again. Nothing is stored
+//if any deck is invalid.
+func ImportDecks(connection *bongo.Connection, decks []DeckFile) error {
+	if err := CheckDecks(decks); err != nil {
+		return err
+	}
+
+	for _, file := range decks {
+		deck := Deck{}
+		err := connection.Collection("deck").FindOne(bson.M{"name": file.Name}, &deck)
+		if _, notFound := err.(*bongo.DocumentNotFoundError); err != nil && !notFound {
+			return err
+		}
+
+		deck.Name = file.Name
+		deck.Description = file.Description
+		if err := connection.Collection("deck").Save(&deck); err != nil {
+			return err
+		}
+
+		_, err = connection.Collection("challenge").Collection().RemoveAll(bson.M{"deckid": deck.ID})
+		if err != nil {
+			return err
+		}
+
+		for _, challenge := range file.challenges(deck.ID) {
+			if err := connection.Collection("challenge").Save(&challenge); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
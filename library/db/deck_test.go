@@ -0,0 +1,211 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Deck", func() {
+	yamlDeck := `
+name: Classic
+description: The basics
+challenges:
+  - text: Everybody who is wearing black drinks
+    category: everyone
+    sips: 2
+  - text: Tell a secret or finish your drink
+    category: dare
+    sips: 5
+    minPlayers: 3
+    maxPlayers: 8
+    timer: 30
+`
+
+	Context("parsing deck files", func() {
+		It("Should read a yaml deck", func() {
+			decks, err := ParseDecks([]byte(yamlDeck), ".yml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decks).To(HaveLen(1))
+			Expect(decks[0].Name).To(Equal("Classic"))
+			Expect(decks[0].Challenges).To(HaveLen(2))
+			Expect(decks[0].Challenges[1]).To(Equal(ChallengeFile{
+				Text:       "Tell a secret or finish your drink",
+				Category:   "dare",
+				Sips:       5,
+				MinPlayers: 3,
+				MaxPlayers: 8,
+				Timer:      30,
+			}))
+		})
+
+		It("Should read a list of json decks", func() {
+			decks, err := ParseDecks([]byte(`[
+				{"name": "A", "challenges": [{"text": "Drink", "category": "everyone", "sips": 1, "minPlayers": 2}]},
+				{"name": "B"}
+			]`), "JSON")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(decks).To(HaveLen(2))
+			Expect(decks[0].Challenges[0].MinPlayers).To(Equal(2))
+			Expect(decks[1].Name).To(Equal("B"))
+		})
+
+		It("Should refuse unknown formats", func() {
+			_, err := ParseDecks([]byte(yamlDeck), ".txt")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("validating challenges", func() {
+		It("Should accept valid decks", func() {
+			decks, err := ParseDecks([]byte(yamlDeck), "yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(CheckDecks(decks)).To(Succeed())
+		})
+
+		It("Should refuse broken challenges", func() {
+			challenge := Challenge{Text: "", Category: "dare", Sips: 100, MinPlayers: 5, MaxPlayers: 2, Timer: -1}
+			errs := challenge.Validate(nil)
+			var pointers []string
+			for _, err := range errs {
+				pointers = append(pointers, err.(fieldError).Pointer())
+			}
+
+			Expect(pointers).To(ConsistOf(
+				"/data/attributes/text",
+				"/data/relationships/deck",
+				"/data/attributes/sips",
+				"/data/attributes/timer",
+				"/data/attributes/maxPlayers",
+			))
+		})
+
+		It("Should name the broken challenge of a deck", func() {
+			err := CheckDecks([]DeckFile{{Name: "Broken", Challenges: []ChallengeFile{{Text: "Drink", Category: "everyone"}, {Category: "dare"}}}})
+			Expect(err).To(MatchError(ContainSubstring("challenge 2")))
+		})
+
+		It("Should only fit the player range", func() {
+			challenge := Challenge{MinPlayers: 3, MaxPlayers: 5}
+			Expect(challenge.Fits(2)).To(BeFalse())
+			Expect(challenge.Fits(3)).To(BeTrue())
+			Expect(challenge.Fits(6)).To(BeFalse())
+			Expect(Challenge{}.Fits(42)).To(BeTrue())
+		})
+	})
+
+	Context("importing decks", func() {
+		var connection *bongo.Connection
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should replace a deck with the same name", func() {
+			decks, err := ParseDecks([]byte(yamlDeck), "yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ImportDecks(connection, decks)).To(Succeed())
+
+			decks[0].Challenges = decks[0].Challenges[:1]
+			Expect(ImportDecks(connection, decks)).To(Succeed())
+
+			count, err := connection.Collection("deck").Collection().Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+
+			count, err = connection.Collection("challenge").Collection().Find(bson.M{}).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+
+	It("Should refuse changes to decks without session", func() {
+		auth := NewAuthenticator("secret", DefaultTokenLifetime)
+		_, err := DeckSource{auth: auth}.Create(Deck{Name: "Classic"}, api2go.Request{})
+		Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+		_, err = ChallengeSource{auth: auth}.Delete(bson.NewObjectId().Hex(), api2go.Request{})
+		Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+	})
+
+	Context("owning decks", func() {
+		var (
+			connection *bongo.Connection
+			auth       *Authenticator
+			decks      DeckSource
+			challenges ChallengeSource
+			owner      User
+			other      User
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			auth = NewAuthenticator("secret", DefaultTokenLifetime)
+			decks = DeckSource{connection: connection, auth: auth}
+			challenges = ChallengeSource{connection: connection, auth: auth}
+			owner, other = User{Username: "owner"}, User{Username: "other"}
+			Expect(connection.Collection("user").Save(&owner)).To(Succeed())
+			Expect(connection.Collection("user").Save(&other)).To(Succeed())
+		})
+
+		It("Should only let the owner change the deck and its challenges", func() {
+			created, err := decks.Create(Deck{Name: "Classic"}, sessionRequest(auth, owner.ID))
+			Expect(err).ToNot(HaveOccurred())
+			deck := created.Result().(Deck)
+			Expect(deck.OwnerID).To(Equal(owner.ID))
+
+			challenge := Challenge{DeckID: deck.ID, Text: "Drink", Category: "everyone", Sips: 1}
+			_, err = challenges.Create(challenge, sessionRequest(auth, other.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+			added, err := challenges.Create(challenge, sessionRequest(auth, owner.ID))
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = challenges.Delete(added.Result().(Challenge).GetID(), sessionRequest(auth, other.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			deck.Name = "Taken"
+			_, err = decks.Update(deck, sessionRequest(auth, other.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+			_, err = decks.Delete(deck.GetID(), sessionRequest(auth, other.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			_, err = decks.Delete(deck.GetID(), sessionRequest(auth, owner.ID))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should let admins change every deck but not guests", func() {
+			Expect(ImportDecks(connection, []DeckFile{{Name: "Classic"}})).To(Succeed())
+			imported := Deck{}
+			Expect(connection.Collection("deck").FindOne(bson.M{"name": "Classic"}, &imported)).To(Succeed())
+
+			_, err := decks.Update(imported, sessionRequest(auth, owner.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			Expect(SetUserAdmin(connection, "owner", true)).To(Succeed())
+			_, err = decks.Update(imported, sessionRequest(auth, owner.ID))
+			Expect(err).ToNot(HaveOccurred())
+
+			guest, _, err := newGuest("Partyhat")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(connection.Collection("user").Save(&guest)).To(Succeed())
+			_, err = decks.Create(Deck{Name: "Guests"}, sessionRequest(auth, guest.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
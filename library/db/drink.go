@@ -0,0 +1,332 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/api2go/jsonapi"
+	"github.com/manyminds/soyfr/library/common"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//DrinkReason tells why a player had to drink
+type DrinkReason string
+
+const (
+	//DrinkReasonChallenge is a completed challenge
+	DrinkReasonChallenge DrinkReason = "challenge"
+	//DrinkReasonVote is a lost voting round
+	DrinkReasonVote DrinkReason = "vote"
+	//DrinkReasonPenalty is a turn that timed out or a penalty of the host
+	DrinkReasonPenalty DrinkReason = "penalty"
+	//DrinkReasonRule is handed out by a house rule of the game
+	DrinkReasonRule DrinkReason = "rule"
+)
+
+//IsValid returns true for all known reasons
+func (r DrinkReason) IsValid() bool {
+	switch r {
+	case DrinkReasonChallenge, DrinkReasonVote, DrinkReasonPenalty, DrinkReasonRule:
+		return true
+	}
+
+	return false
+}
+
+//UnmarshalJSON lets api2go set the reason from a plain json string
+func (r *DrinkReason) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*r = DrinkReason(value)
+	return nil
+}
+
+//DrinkEvent is an entry of the drink ledger, one player drank
+//an amount of sips in a game
+type DrinkEvent struct {
+	ID      bson.ObjectId `bson:"_id"`
+	UserID  bson.ObjectId `json:"-"`
+	GameID  bson.ObjectId `json:"-"`
+	Reason  DrinkReason
+	Sips    int
+	Created time.Time
+	exists  bool
+}
+
+//SetIsNew satisfies the document base
+func (d *DrinkEvent) SetIsNew(isNew bool) {
+	d.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (d DrinkEvent) IsNew() bool {
+	return !d.exists
+}
+
+//GetId Satisfy the document interface
+func (d DrinkEvent) GetId() bson.ObjectId {
+	return d.ID
+}
+
+//SetId satisfy the document interface
+func (d *DrinkEvent) SetId(id bson.ObjectId) {
+	d.ID = id
+}
+
+//GetID to satisfy api2go interface
+func (d DrinkEvent) GetID() string {
+	return d.ID.Hex()
+}
+
+//SetID to satisfy api2go interface
+func (d *DrinkEvent) SetID(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("invalid id %s", id)
+	}
+
+	d.ID = bson.ObjectIdHex(id)
+	return nil
+}
+
+//GetReferences to satisfy the api2go relation interface
+func (d DrinkEvent) GetReferences() []jsonapi.Reference {
+	return []jsonapi.Reference{
+		{Type: "users", Name: "user"},
+		{Type: "games", Name: "game"},
+	}
+}
+
+//GetReferencedIDs to satisfy the api2go relation interface
+func (d DrinkEvent) GetReferencedIDs() []jsonapi.ReferenceID {
+	var result []jsonapi.ReferenceID
+	if d.UserID.Valid() {
+		result = append(result, jsonapi.ReferenceID{ID: d.UserID.Hex(), Type: "users", Name: "user"})
+	}
+
+	if d.GameID.Valid() {
+		result = append(result, jsonapi.ReferenceID{ID: d.GameID.Hex(), Type: "games", Name: "game"})
+	}
+
+	return result
+}
+
+//SetToOneReferenceID to satisfy the api2go relation interface
+func (d *DrinkEvent) SetToOneReferenceID(name, ID string) error {
+	if !bson.IsObjectIdHex(ID) {
+		return fmt.Errorf("invalid %s id %s", name, ID)
+	}
+
+	switch name {
+	case "user":
+		d.UserID = bson.ObjectIdHex(ID)
+	case "game":
+		d.GameID = bson.ObjectIdHex(ID)
+	default:
+		return fmt.Errorf("there is no to-one relationship with the name %s", name)
+	}
+
+	return nil
+}
+
+//Validate satisfies the bongo validate hook
+func (d DrinkEvent) Validate(c *bongo.Collection) []error {
+	var errs []error
+	if !d.UserID.Valid() {
+		errs = append(errs, fieldError{Field: "user", Msg: "A drink needs a player", Relationship: true})
+	}
+
+	if !d.GameID.Valid() {
+		errs = append(errs, fieldError{Field: "game", Msg: "A drink needs a game", Relationship: true})
+	}
+
+	if !d.Reason.IsValid() {
+		errs = append(errs, fieldError{Field: "reason", Msg: "Must be challenge, vote, penalty or rule"})
+	}
+
+	if err := between("sips", d.Sips, 1, maxSips); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+//drinkLedger records drink events
+type drinkLedger struct {
+	connection *bongo.Connection
+}
+
+//record stores that a player drank, nothing is stored for zero sips
+func (l drinkLedger) record(userID, gameID bson.ObjectId, reason DrinkReason, sips int) error {
+	if sips <= 0 {
+		return nil
+	}
+
+	event := DrinkEvent{
+		UserID:  userID,
+		GameID:  gameID,
+		Reason:  reason,
+		Sips:    sips,
+		Created: time.Now(),
+	}
+
+	return l.connection.Collection("drinkEvent").Save(&event)
+}
+
+//effects records the sips of house rules, every player drinks no more
+//than the personal limit allows
+func (l drinkLedger) effects(guard safeguard, gameID bson.ObjectId, effects []game.Effect) {
+	for _, effect := range effects {
+		if effect.Sips <= 0 || !bson.IsObjectIdHex(effect.Player) {
+			continue
+		}
+
+		sips := limitSips(guard.limit(effect.Player, gameID), effect.Sips)
+		if err := l.record(bson.ObjectIdHex(effect.Player), gameID, DrinkReasonRule, sips); err != nil {
+			log.Printf("could not record drink of %s: %s\n", effect.Player, err)
+		}
+	}
+}
+
+//DrinkEventSource for api2go, the ledger can be read and the host can
+//hand out penalties, entries can not be changed afterwards
+type DrinkEventSource struct {
+	connection *bongo.Connection
+	auth       *Authenticator
+}
+
+//drinkEventSortable are the fields drink events can be sorted by
+var drinkEventSortable = map[string]string{"created": "created", "sips": "sips"}
+
+//FindAll satisfies api2go data source interface
+func (s DrinkEventSource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	_, response, err := s.PaginatedFindAll(r)
+	return response, err
+}
+
+//PaginatedFindAll satisfies api2go paging interface,
+//filter[user] and filter[game] select the events of a player or game
+func (s DrinkEventSource) PaginatedFindAll(r api2go.Request) (uint, api2go.Responder, error) {
+	events := []DrinkEvent{}
+	event := DrinkEvent{}
+
+	query, err := parseListQuery(r, drinkEventSortable, nil)
+	if err != nil {
+		return 0, &common.Response{}, err
+	}
+
+	for name, field := range map[string]string{"user": "userid", "game": "gameid"} {
+		value := queryParam(r, "filter["+name+"]")
+		if value == "" {
+			continue
+		}
+
+		if !bson.IsObjectIdHex(value) {
+			return 0, &common.Response{}, parameterError("filter["+name+"]", "Invalid "+name+" id")
+		}
+
+		query.Filter[field] = bson.ObjectIdHex(value)
+	}
+
+	resultSet, total, err := query.find(s.connection.Collection("drinkEvent"))
+	if err != nil {
+		return 0, &common.Response{}, mapError(err)
+	}
+
+	for resultSet.Next(&event) {
+		events = append(events, event)
+	}
+
+	if resultSet.Error != nil {
+		return 0, &common.Response{}, mapError(resultSet.Error)
+	}
+
+	meta := map[string]interface{}{"total": total}
+	if len(events) == query.Limit {
+		meta["next"] = events[len(events)-1].GetID()
+	}
+
+	return total, &common.Response{Res: events, Code: http.StatusOK, Meta: meta}, nil
+}
+
+//FindOne satisfies api2go data source interface
+func (s DrinkEventSource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	id, err := parseID(ID)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	event := DrinkEvent{}
+	err = s.connection.Collection("drinkEvent").FindById(id, &event)
+
+	return &common.Response{Res: event, Code: http.StatusOK}, mapError(err)
+}
+
+//Create satisfies api2go create interface, only the host can hand
+//out penalties to the players of the game and only within their limits
+func (s DrinkEventSource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	event, ok := obj.(DrinkEvent)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	hostID, err := s.auth.requestUser(r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if errs := event.Validate(nil); len(errs) > 0 {
+		return &common.Response{}, saveError(&bongo.ValidationError{Errors: errs})
+	}
+
+	if event.Reason != DrinkReasonPenalty {
+		reasonErr := fieldError{Field: "reason", Msg: "Only penalties can be handed out"}
+		return &common.Response{}, saveError(&bongo.ValidationError{Errors: []error{reasonErr}})
+	}
+
+	stored, err := hostedGame(s.connection, event.GameID, hostID)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if !stored.HasPlayer(event.UserID) {
+		return &common.Response{}, apiError(nil, http.StatusNotFound, "The user is not a player of this game", "")
+	}
+
+	//penalties respect the personal limits just like the turns do
+	event.Sips = limitSips(newSafeguard(s.connection).limit(event.UserID.Hex(), event.GameID), event.Sips)
+	if event.Sips == 0 {
+		return &common.Response{}, apiError(nil, http.StatusConflict, "The player can not drink any more", "")
+	}
+
+	//the ledger is append only, an id sent by the client would
+	//overwrite the entry stored under it
+	event.ID = bson.NewObjectId()
+	event.Created = time.Now()
+	err = s.connection.Collection("drinkEvent").Save(&event)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: event, Code: http.StatusCreated}, nil
+}
+
+//Update satisfies api2go update interface, the ledger is append only
+func (s DrinkEventSource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	return &common.Response{}, apiError(nil, http.StatusForbidden, "Forbidden", "Drink events can not be changed")
+}
+
+//Delete satisfies api2go delete interface, entries stay in the ledger
+//because the safeguard limits are counted from it
+func (s DrinkEventSource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
+	return &common.Response{}, apiError(nil, http.StatusForbidden, "Forbidden", "Drink events can not be deleted")
+}
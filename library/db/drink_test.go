@@ -0,0 +1,129 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Drink", func() {
+	It("Should accept a complete drink event", func() {
+		event := DrinkEvent{UserID: bson.NewObjectId(), GameID: bson.NewObjectId(), Reason: DrinkReasonVote, Sips: 2}
+		Expect(event.Validate(nil)).To(BeEmpty())
+	})
+
+	It("Should refuse drinks without player, game, reason or sips", func() {
+		var pointers []string
+		for _, err := range (DrinkEvent{Reason: "because"}).Validate(nil) {
+			pointers = append(pointers, err.(fieldError).Pointer())
+		}
+
+		Expect(pointers).To(ConsistOf(
+			"/data/relationships/user",
+			"/data/relationships/game",
+			"/data/attributes/reason",
+			"/data/attributes/sips",
+		))
+	})
+
+	It("Should link the player and the game", func() {
+		event := DrinkEvent{}
+		userID, gameID := bson.NewObjectId(), bson.NewObjectId()
+		Expect(event.SetToOneReferenceID("user", userID.Hex())).To(Succeed())
+		Expect(event.SetToOneReferenceID("game", gameID.Hex())).To(Succeed())
+		Expect(event.UserID).To(Equal(userID))
+		Expect(event.GameID).To(Equal(gameID))
+	})
+
+	It("Should only let logged in users hand out penalties", func() {
+		auth := NewAuthenticator("secret", DefaultTokenLifetime)
+		source := DrinkEventSource{auth: auth}
+		event := DrinkEvent{UserID: bson.NewObjectId(), GameID: bson.NewObjectId(), Reason: DrinkReasonVote, Sips: 2}
+
+		_, err := source.Create(event, api2go.Request{})
+		Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+
+		_, err = source.Create(event, sessionRequest(auth, bson.NewObjectId()))
+		Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should never delete entries", func() {
+		_, err := DrinkEventSource{}.Delete(bson.NewObjectId().Hex(), api2go.Request{})
+		Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+	})
+
+	Context("handing out penalties", func() {
+		var (
+			source DrinkEventSource
+			auth   *Authenticator
+			stored Game
+			host   bson.ObjectId
+			player bson.ObjectId
+		)
+
+		BeforeEach(func() {
+			connection, err := bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			auth = NewAuthenticator("secret", DefaultTokenLifetime)
+			source = DrinkEventSource{connection: connection, auth: auth}
+			host, player = bson.NewObjectId(), bson.NewObjectId()
+			stored = Game{HostID: host, PlayerIDs: []bson.ObjectId{host, player}, JoinCode: newJoinCode(2), State: GameStateRunning}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+		})
+
+		penalty := func(userID bson.ObjectId) DrinkEvent {
+			return DrinkEvent{UserID: userID, GameID: stored.ID, Reason: DrinkReasonPenalty, Sips: 2}
+		}
+
+		It("Should let the host hand out penalties to players", func() {
+			created, err := source.Create(penalty(player), sessionRequest(auth, host))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.StatusCode()).To(Equal(http.StatusCreated))
+		})
+
+		It("Should keep penalties within the limits of the player", func() {
+			Expect(source.connection.Collection("user").Save(&User{ID: player, Username: "Limited", SipsPerGameLimit: 1})).To(Succeed())
+			created, err := source.Create(penalty(player), sessionRequest(auth, host))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.Result().(DrinkEvent).Sips).To(Equal(1))
+
+			_, err = source.Create(penalty(player), sessionRequest(auth, host))
+			Expect(statusOf(err)).To(Equal(http.StatusConflict))
+		})
+
+		It("Should never overwrite ledger entries with the id sent by the client", func() {
+			created, err := source.Create(penalty(player), sessionRequest(auth, host))
+			Expect(err).ToNot(HaveOccurred())
+			first := created.Result().(DrinkEvent)
+
+			event := penalty(player)
+			event.ID = first.ID
+			created, err = source.Create(event, sessionRequest(auth, host))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.Result().(DrinkEvent).ID).ToNot(Equal(first.ID))
+
+			count, err := source.connection.Collection("drinkEvent").Collection().Find(bson.M{"gameid": stored.ID}).Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(2))
+		})
+
+		It("Should refuse penalties of other users and for strangers", func() {
+			_, err := source.Create(penalty(host), sessionRequest(auth, player))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			_, err = source.Create(penalty(bson.NewObjectId()), sessionRequest(auth, host))
+			Expect(statusOf(err)).To(Equal(http.StatusNotFound))
+		})
+
+		AfterEach(func() {
+			if con, err := bongo.Connect(getDatabaseConfiguration()); err == nil {
+				con.Session.DB(testDatabase).DropDatabase()
+			}
+		})
+	})
+})
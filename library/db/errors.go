@@ -0,0 +1,141 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//apiError returns an http error with a single json api error object,
+//err is only logged and never sent to the client
+func apiError(err error, status int, title, detail string) api2go.HTTPError {
+	httpErr := api2go.NewHTTPError(err, title, status)
+	httpErr.Errors = []api2go.Error{{
+		Status: strconv.Itoa(status),
+		Title:  title,
+		Detail: detail,
+	}}
+
+	return httpErr
+}
+
+//parameterError is a bad request caused by a query parameter
+func parameterError(parameter, msg string) error {
+	err := apiError(nil, http.StatusBadRequest, msg, "")
+	err.Errors[0].Source = &api2go.ErrorSource{Parameter: parameter}
+
+	return err
+}
+
+//parseID turns an id given by a client into an object id,
+//invalid ids are answered with 400 instead of a panic
+func parseID(ID string) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(ID) {
+		return "", apiError(nil, http.StatusBadRequest, "Invalid id", fmt.Sprintf("%s is not a valid id", ID))
+	}
+
+	return bson.ObjectIdHex(ID), nil
+}
+
+//mapError turns database errors into json api errors. Missing documents
+//become 404, http errors are kept and everything else is a 500.
+func mapError(err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case api2go.HTTPError:
+		return err
+	case *bongo.DocumentNotFoundError, bongo.DocumentNotFoundError:
+		return apiError(err, http.StatusNotFound, "Not found", "The requested resource does not exist")
+	}
+
+	if err == mgo.ErrNotFound {
+		return apiError(err, http.StatusNotFound, "Not found", "The requested resource does not exist")
+	}
+
+	return apiError(err, http.StatusInternalServerError, "Internal server error", "")
+}
+
+//duplicateIndex finds the unique index in a duplicate key error message
+var duplicateIndex = regexp.MustCompile(`index: (?:\S+\$)?(\w+?)_1\b`)
+
+//uniqueAttributes maps fields with a unique index to their json attribute
+var uniqueAttributes = map[string]string{
+	"username": "username",
+	"joincode": "joinCode",
+	"name":     "name",
+}
+
+//conflictError answers a duplicate key error with 409, the error points
+//to the attribute if the index is known
+func conflictError(err error) error {
+	httpErr := apiError(err, http.StatusConflict, "Conflict", "A document with the same value already exists")
+
+	match := duplicateIndex.FindStringSubmatch(err.Error())
+	if match == nil {
+		return httpErr
+	}
+
+	if attribute, ok := uniqueAttributes[match[1]]; ok {
+		httpErr.Errors[0].Title = "Already taken"
+		httpErr.Errors[0].Detail = fmt.Sprintf("The %s is already taken", attribute)
+		httpErr.Errors[0].Source = &api2go.ErrorSource{Pointer: "/data/attributes/" + attribute}
+	}
+
+	return httpErr
+}
+
+//saveError turns a failed save into a json api error, every violated
+//validation rule gets its own error object pointing to the attribute
+//and duplicates of unique fields are a conflict, everything else is
+//left to mapError so database messages never reach the client
+func saveError(err error) error {
+	if mgo.IsDup(err) {
+		return conflictError(err)
+	}
+
+	validationErr, ok := err.(*bongo.ValidationError)
+	if !ok {
+		return mapError(err)
+	}
+
+	httpErr := apiError(err, http.StatusBadRequest, "Validation failed", "")
+	httpErr.Errors = nil
+	for _, violation := range validationErr.Errors {
+		apiErr := api2go.Error{
+			Status: strconv.Itoa(http.StatusBadRequest),
+			Title:  "Validation failed",
+			Detail: violation.Error(),
+		}
+
+		if fieldErr, ok := violation.(fieldError); ok {
+			apiErr.Detail = fieldErr.Msg
+			apiErr.Source = &api2go.ErrorSource{Pointer: fieldErr.Pointer()}
+		}
+
+		httpErr.Errors = append(httpErr.Errors, apiErr)
+	}
+
+	return httpErr
+}
+
+//statusOf returns the status of a json api error, other errors are a 500
+func statusOf(err error) int {
+	httpErr, ok := err.(api2go.HTTPError)
+	if !ok || len(httpErr.Errors) == 0 {
+		return http.StatusInternalServerError
+	}
+
+	status, convErr := strconv.Atoi(httpErr.Errors[0].Status)
+	if convErr != nil {
+		return http.StatusInternalServerError
+	}
+
+	return status
+}
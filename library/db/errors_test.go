@@ -0,0 +1,67 @@
+package db
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Errors", func() {
+	status := func(err error) string {
+		httpErr, ok := err.(api2go.HTTPError)
+		Expect(ok).To(BeTrue())
+		Expect(httpErr.Errors).To(HaveLen(1))
+
+		return httpErr.Errors[0].Status
+	}
+
+	It("Should parse valid ids", func() {
+		id := bson.NewObjectId()
+		parsed, err := parseID(id.Hex())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(id))
+	})
+
+	It("Should answer malformed ids with 400", func() {
+		_, err := parseID("not-an-id")
+		Expect(status(err)).To(Equal("400"))
+	})
+
+	It("Should map missing documents to 404", func() {
+		Expect(status(mapError(&bongo.DocumentNotFoundError{}))).To(Equal("404"))
+		Expect(status(mapError(mgo.ErrNotFound))).To(Equal("404"))
+	})
+
+	It("Should keep http errors", func() {
+		err := api2go.NewHTTPError(nil, "Conflict", http.StatusConflict)
+		Expect(mapError(err)).To(Equal(err))
+	})
+
+	It("Should answer duplicate keys with 409 pointing to the attribute", func() {
+		dup := &mgo.LastError{Code: 11000, Err: `E11000 duplicate key error index: soyfer_test.user.$username_1 dup key: { : "Unittest" }`}
+		err := saveError(dup)
+		Expect(statusOf(err)).To(Equal(http.StatusConflict))
+		Expect(err.(api2go.HTTPError).Errors[0].Source.Pointer).To(Equal("/data/attributes/username"))
+
+		dup = &mgo.LastError{Code: 11000, Err: `E11000 duplicate key error collection: soyfer_test.game index: joincode_1 dup key: { joincode: "BEER42" }`}
+		err = saveError(dup)
+		Expect(err.(api2go.HTTPError).Errors[0].Source.Pointer).To(Equal("/data/attributes/joinCode"))
+	})
+
+	It("Should map everything else to 500", func() {
+		Expect(mapError(nil)).To(BeNil())
+		Expect(status(mapError(errors.New("connection lost")))).To(Equal("500"))
+	})
+
+	It("Should not leak database errors of failed saves", func() {
+		err := saveError(errors.New("write concern timeout on db1:27017"))
+		Expect(statusOf(err)).To(Equal(http.StatusInternalServerError))
+		Expect(err.(api2go.HTTPError).Errors[0].Detail).To(BeEmpty())
+	})
+})
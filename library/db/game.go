@@ -0,0 +1,423 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/api2go/jsonapi"
+	"github.com/manyminds/soyfr/library/common"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//GameState is the lifecycle state of a game
+type GameState string
+
+const (
+	//GameStateLobby is the initial state, players can join
+	GameStateLobby GameState = "lobby"
+	//GameStateRunning means turns are being played
+	GameStateRunning GameState = "running"
+	//GameStatePaused means the game is interrupted but can be resumed
+	GameStatePaused GameState = "paused"
+	//GameStateFinished is final, a finished game can not be restarted
+	GameStateFinished GameState = "finished"
+)
+
+//gameTransitions lists all states that can be reached from a state
+var gameTransitions = map[GameState][]GameState{
+	GameStateLobby:   {GameStateRunning, GameStateFinished},
+	GameStateRunning: {GameStatePaused, GameStateFinished},
+	GameStatePaused:  {GameStateRunning, GameStateFinished},
+}
+
+//IsValid returns true for all known states
+func (s GameState) IsValid() bool {
+	switch s {
+	case GameStateLobby, GameStateRunning, GameStatePaused, GameStateFinished:
+		return true
+	}
+
+	return false
+}
+
+//CanTransitionTo checks if the state may change to next.
+//Staying in the same state is always allowed.
+func (s GameState) CanTransitionTo(next GameState) bool {
+	if s == next {
+		return true
+	}
+
+	for _, allowed := range gameTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+//UnmarshalJSON lets api2go set the state from a plain json string
+func (s *GameState) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*s = GameState(value)
+	return nil
+}
+
+//Game is a party session hosted by one user
+type Game struct {
+	ID        bson.ObjectId   `bson:"_id"`
+	HostID    bson.ObjectId   `json:"-"`
+	PlayerIDs []bson.ObjectId `json:"-"`
+	DeckID    bson.ObjectId   `json:"-"`
+	JoinCode  string
+	State     GameState
+	Rules     []game.RuleSpec `json:"rules"`
+	BannedIDs []bson.ObjectId `json:"-"`
+	MutedIDs  []bson.ObjectId `json:"-"`
+	TurnState *game.Snapshot  `json:"-"`
+	OpenVote  *storedRound    `json:"-"`
+	exists    bool
+}
+
+//SetIsNew satisfies the document base
+func (g *Game) SetIsNew(isNew bool) {
+	g.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (g Game) IsNew() bool {
+	return !g.exists
+}
+
+//GetId Satisfy the document interface
+func (g Game) GetId() bson.ObjectId {
+	return g.ID
+}
+
+//SetId satisfy the document interface
+func (g *Game) SetId(id bson.ObjectId) {
+	g.ID = id
+}
+
+//GetID to satisfy api2go interface
+func (g Game) GetID() string {
+	return g.ID.Hex()
+}
+
+//SetID to satisfy api2go interface
+func (g *Game) SetID(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("invalid id %s", id)
+	}
+
+	g.ID = bson.ObjectIdHex(id)
+	return nil
+}
+
+//GetReferences to satisfy the api2go relation interface
+func (g Game) GetReferences() []jsonapi.Reference {
+	return []jsonapi.Reference{
+		{Type: "users", Name: "host"},
+		{Type: "users", Name: "players"},
+		{Type: "decks", Name: "deck"},
+	}
+}
+
+//GetReferencedIDs to satisfy the api2go relation interface
+func (g Game) GetReferencedIDs() []jsonapi.ReferenceID {
+	var result []jsonapi.ReferenceID
+	if g.HostID.Valid() {
+		result = append(result, jsonapi.ReferenceID{ID: g.HostID.Hex(), Type: "users", Name: "host"})
+	}
+
+	for _, playerID := range g.PlayerIDs {
+		result = append(result, jsonapi.ReferenceID{ID: playerID.Hex(), Type: "users", Name: "players"})
+	}
+
+	if g.DeckID.Valid() {
+		result = append(result, jsonapi.ReferenceID{ID: g.DeckID.Hex(), Type: "decks", Name: "deck"})
+	}
+
+	return result
+}
+
+//SetToOneReferenceID to satisfy the api2go relation interface
+func (g *Game) SetToOneReferenceID(name, ID string) error {
+	if !bson.IsObjectIdHex(ID) {
+		return fmt.Errorf("invalid %s id %s", name, ID)
+	}
+
+	switch name {
+	case "host":
+		g.HostID = bson.ObjectIdHex(ID)
+	case "deck":
+		g.DeckID = bson.ObjectIdHex(ID)
+	default:
+		return fmt.Errorf("there is no to-one relationship with the name %s", name)
+	}
+
+	return nil
+}
+
+//SetToManyReferenceIDs to satisfy the api2go relation interface
+func (g *Game) SetToManyReferenceIDs(name string, IDs []string) error {
+	if name != "players" {
+		return fmt.Errorf("there is no to-many relationship with the name %s", name)
+	}
+
+	var players []bson.ObjectId
+	for _, ID := range IDs {
+		if !bson.IsObjectIdHex(ID) {
+			return fmt.Errorf("invalid player id %s", ID)
+		}
+
+		players = append(players, bson.ObjectIdHex(ID))
+	}
+
+	g.PlayerIDs = players
+	return nil
+}
+
+//containsID checks if id is one of ids
+func containsID(ids []bson.ObjectId, id bson.ObjectId) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+//HasPlayer checks if the user takes part in the game
+func (g Game) HasPlayer(userID bson.ObjectId) bool {
+	return containsID(g.PlayerIDs, userID)
+}
+
+//IsBanned checks if the host banned the user from the game
+func (g Game) IsBanned(userID bson.ObjectId) bool {
+	return containsID(g.BannedIDs, userID)
+}
+
+//IsMuted checks if the host muted the chat messages of the user
+func (g Game) IsMuted(userID bson.ObjectId) bool {
+	return containsID(g.MutedIDs, userID)
+}
+
+//HouseRules returns the enabled rules of the game, rules that can not
+//be built are left out
+func (g Game) HouseRules() game.Rules {
+	rules, err := game.NewRules(g.Rules)
+	if err != nil {
+		log.Printf("could not use the house rules of game %s: %s\n", g.ID.Hex(), err)
+		return nil
+	}
+
+	return rules
+}
+
+//hostedGame returns the game if actor is its host
+func hostedGame(connection *bongo.Connection, gameID, actor bson.ObjectId) (Game, error) {
+	game := Game{}
+	if err := connection.Collection("game").FindById(gameID, &game); err != nil {
+		return game, mapError(err)
+	}
+
+	if game.HostID != actor {
+		return game, apiError(nil, http.StatusForbidden, "Only the host can do that", "")
+	}
+
+	return game, nil
+}
+
+//validateRules answers with 400 for unknown rules or params
+func validateRules(specs []game.RuleSpec) error {
+	if _, err := game.NewRules(specs); err != nil {
+		return api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+	}
+
+	return nil
+}
+
+//GameSource for api2go
+type GameSource struct {
+	connection *bongo.Connection
+	auth       *Authenticator
+}
+
+//FindAll satisfies api2go data source interface
+func (s GameSource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	games := []Game{}
+	game := Game{}
+	resultSet := s.connection.Collection("game").Find(bson.M{})
+	if resultSet.Error != nil {
+		return &common.Response{}, mapError(resultSet.Error)
+	}
+
+	for resultSet.Next(&game) {
+		games = append(games, game)
+	}
+
+	return &common.Response{Res: games, Code: http.StatusOK}, nil
+}
+
+//FindOne satisfies api2go data source interface
+func (s GameSource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	id, err := parseID(ID)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	game := Game{}
+	err = s.connection.Collection("game").FindById(id, &game)
+
+	return &common.Response{Res: game, Code: http.StatusOK}, mapError(err)
+}
+
+//Create satisfies api2go create interface, the logged in user hosts the game
+func (s GameSource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	game, ok := obj.(Game)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	hostID, err := s.auth.requestUser(r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if game.State == "" {
+		game.State = GameStateLobby
+	}
+
+	if game.State != GameStateLobby {
+		return &common.Response{}, api2go.NewHTTPError(nil, "A new game must start in the lobby", http.StatusBadRequest)
+	}
+
+	game.HostID = hostID
+	if err := validateRules(game.Rules); err != nil {
+		return &common.Response{}, err
+	}
+
+	if !game.HasPlayer(game.HostID) {
+		game.PlayerIDs = append(game.PlayerIDs, game.HostID)
+	}
+
+	game.JoinCode = normalizeJoinCode(game.JoinCode)
+	if game.JoinCode == "" {
+		code, err := uniqueJoinCode(s.joinCodeTaken)
+		if err != nil {
+			return &common.Response{}, mapError(err)
+		}
+
+		game.JoinCode = code
+	} else if err := s.checkJoinCode(game.JoinCode); err != nil {
+		return &common.Response{}, err
+	}
+
+	//an id sent by the client would overwrite the game stored under it
+	game.ID = bson.NewObjectId()
+	err = s.connection.Collection("game").Save(&game)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: game, Code: http.StatusCreated}, nil
+}
+
+//Update lets the host change the deck and the house rules and finish a
+//game in the lobby, state changes must follow the lifecycle. Players only
+//change by joining and moderation, starting, pausing and resuming is left
+//to the turns and moderation so the running engine stays in sync.
+func (s GameSource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	game, ok := obj.(Game)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	if !game.State.IsValid() {
+		return &common.Response{}, api2go.NewHTTPError(nil, fmt.Sprintf("Unknown game state %s", game.State), http.StatusBadRequest)
+	}
+
+	actor, err := s.auth.requestUser(r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	stored, err := hostedGame(s.connection, game.ID, actor)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if game.State != stored.State {
+		if !stored.State.CanTransitionTo(game.State) {
+			msg := fmt.Sprintf("A game can not change from %s to %s", stored.State, game.State)
+			return &common.Response{}, api2go.NewHTTPError(nil, msg, http.StatusConflict)
+		}
+
+		if stored.State != GameStateLobby || game.State != GameStateFinished {
+			msg := "Start, pause and resume the game with the turns and moderation"
+			return &common.Response{}, api2go.NewHTTPError(nil, msg, http.StatusConflict)
+		}
+	}
+
+	//house rules only change in the lobby, running engines keep
+	//the rules they were started with
+	if stored.State != GameStateLobby {
+		game.Rules = stored.Rules
+	} else if err := validateRules(game.Rules); err != nil {
+		return &common.Response{}, err
+	}
+
+	//host rights, players, bans and mutes only change through joining
+	//and moderation, the join code was handed out with the invites and
+	//the turns and the open vote are only changed by the server
+	game.HostID = stored.HostID
+	game.JoinCode = stored.JoinCode
+	game.PlayerIDs = stored.PlayerIDs
+	game.BannedIDs = stored.BannedIDs
+	game.MutedIDs = stored.MutedIDs
+	game.TurnState = stored.TurnState
+	game.OpenVote = stored.OpenVote
+	err = s.connection.Collection("game").Save(&game)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: game, Code: http.StatusOK}, nil
+}
+
+//Delete lets the host delete the game
+func (s GameSource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
+	gameID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	actor, err := s.auth.requestUser(r)
+	if err != nil {
+		return nil, err
+	}
+
+	game, err := hostedGame(s.connection, gameID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.connection.Collection("game").DeleteDocument(&game)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &common.Response{Res: game, Code: http.StatusOK}, nil
+}
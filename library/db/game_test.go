@@ -0,0 +1,217 @@
+package db
+
+import (
+	"net/http"
+
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Game", func() {
+	Context("lifecycle states", func() {
+		It("Should allow the regular flow of a game", func() {
+			Expect(GameStateLobby.CanTransitionTo(GameStateRunning)).To(BeTrue())
+			Expect(GameStateRunning.CanTransitionTo(GameStatePaused)).To(BeTrue())
+			Expect(GameStatePaused.CanTransitionTo(GameStateRunning)).To(BeTrue())
+			Expect(GameStateRunning.CanTransitionTo(GameStateFinished)).To(BeTrue())
+		})
+
+		It("Should allow staying in the same state", func() {
+			Expect(GameStateFinished.CanTransitionTo(GameStateFinished)).To(BeTrue())
+		})
+
+		It("Should refuse illegal transitions", func() {
+			Expect(GameStateFinished.CanTransitionTo(GameStateRunning)).To(BeFalse())
+			Expect(GameStateFinished.CanTransitionTo(GameStateLobby)).To(BeFalse())
+			Expect(GameStateRunning.CanTransitionTo(GameStateLobby)).To(BeFalse())
+			Expect(GameStateLobby.CanTransitionTo(GameStatePaused)).To(BeFalse())
+		})
+
+		It("Should only know the defined states", func() {
+			Expect(GameState("lobby").IsValid()).To(BeTrue())
+			Expect(GameState("drunk").IsValid()).To(BeFalse())
+		})
+	})
+
+	Context("relationships", func() {
+		It("Should set the host and the deck", func() {
+			game := Game{}
+			hostID, deckID := bson.NewObjectId(), bson.NewObjectId()
+			Expect(game.SetToOneReferenceID("host", hostID.Hex())).To(Succeed())
+			Expect(game.SetToOneReferenceID("deck", deckID.Hex())).To(Succeed())
+			Expect(game.SetToOneReferenceID("referee", deckID.Hex())).ToNot(Succeed())
+			Expect(game.HostID).To(Equal(hostID))
+			Expect(game.DeckID).To(Equal(deckID))
+			Expect(game.GetReferencedIDs()).To(HaveLen(2))
+		})
+	})
+
+	Context("house rules", func() {
+		It("Should only accept known rules", func() {
+			Expect(validateRules([]game.RuleSpec{{Name: game.RuleWaterfall, Params: map[string]int{"every": 3}}})).To(Succeed())
+			Expect(validateRules([]game.RuleSpec{{Name: "beer-pong"}})).ToNot(Succeed())
+		})
+
+		It("Should leave out rules that can not be built", func() {
+			Expect(Game{Rules: []game.RuleSpec{{Name: game.RuleThumbMaster}}}.HouseRules()).To(HaveLen(1))
+			Expect(Game{Rules: []game.RuleSpec{{Name: "beer-pong"}}}.HouseRules()).To(BeEmpty())
+		})
+	})
+
+	Context("basic game crud model methods", func() {
+		var gameSource GameSource
+		var request api2go.Request
+		var hostID bson.ObjectId
+
+		BeforeEach(func() {
+			connection, err := bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+			auth := NewAuthenticator("secret", DefaultTokenLifetime)
+			gameSource = GameSource{connection: connection, auth: auth}
+			hostID = bson.NewObjectId()
+			request = sessionRequest(auth, hostID)
+		})
+
+		It("Should create a new game in the lobby", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.StatusCode()).To(Equal(http.StatusCreated))
+
+			game := created.Result().(Game)
+			Expect(game.State).To(Equal(GameStateLobby))
+			Expect(game.JoinCode).To(HaveLen(6))
+			Expect(game.HasPlayer(hostID)).To(BeTrue())
+		})
+
+		It("Should refuse a game without session", func() {
+			_, err := gameSource.Create(Game{}, api2go.Request{})
+			Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should let the logged in user host the game", func() {
+			created, err := gameSource.Create(Game{HostID: bson.NewObjectId()}, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created.Result().(Game).HostID).To(Equal(hostID))
+		})
+
+		It("Should never overwrite a game with the id sent by the client", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			stored := created.Result().(Game)
+
+			stranger := bson.NewObjectId()
+			taken, err := gameSource.Create(Game{ID: stored.ID}, sessionRequest(gameSource.auth, stranger))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(taken.Result().(Game).ID).ToNot(Equal(stored.ID))
+
+			found, err := gameSource.FindOne(stored.GetID(), request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.Result().(Game).HostID).To(Equal(hostID))
+		})
+
+		It("Should check join codes chosen by the host and keep them", func() {
+			created, err := gameSource.Create(Game{JoinCode: "beer 42"}, request)
+			Expect(err).ToNot(HaveOccurred())
+			stored := created.Result().(Game)
+			Expect(stored.JoinCode).To(Equal("BEER42"))
+
+			_, err = gameSource.Create(Game{JoinCode: "Beer-42"}, request)
+			Expect(statusOf(err)).To(Equal(http.StatusConflict))
+			_, err = gameSource.Create(Game{JoinCode: "beer#42"}, request)
+			Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+
+			stored.JoinCode = "party 99"
+			updated, err := gameSource.Update(stored, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Result().(Game).JoinCode).To(Equal("BEER42"))
+		})
+
+		It("Should only let the host change or delete the game", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			stored := created.Result().(Game)
+			stranger := sessionRequest(gameSource.auth, bson.NewObjectId())
+
+			_, err = gameSource.Update(stored, stranger)
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+			_, err = gameSource.Delete(stored.GetID(), stranger)
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+			_, err = gameSource.Delete(stored.GetID(), api2go.Request{})
+			Expect(statusOf(err)).To(Equal(http.StatusUnauthorized))
+
+			_, err = gameSource.Delete(stored.GetID(), request)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should refuse to restart a finished game", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			game := created.Result().(Game)
+
+			By("finishing it")
+			game.State = GameStateFinished
+			_, err = gameSource.Update(game, request)
+			Expect(err).ToNot(HaveOccurred())
+
+			By("running it again")
+			game.State = GameStateRunning
+			_, err = gameSource.Update(game, request)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("409"))
+		})
+
+		It("Should refuse unknown states and leave starting to the turns", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			game := created.Result().(Game)
+
+			game.State = "party"
+			_, err = gameSource.Update(game, request)
+			Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+
+			game.State = GameStateRunning
+			_, err = gameSource.Update(game, request)
+			Expect(statusOf(err)).To(Equal(http.StatusConflict))
+		})
+
+		It("Should keep the players of the server", func() {
+			created, err := gameSource.Create(Game{}, request)
+			Expect(err).ToNot(HaveOccurred())
+			stored := created.Result().(Game)
+
+			stored.PlayerIDs = []bson.ObjectId{bson.NewObjectId()}
+			updated, err := gameSource.Update(stored, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Result().(Game).PlayerIDs).To(Equal([]bson.ObjectId{hostID}))
+		})
+
+		It("Should only change the house rules in the lobby", func() {
+			rules := []game.RuleSpec{{Name: game.RuleLastVoterDrinks}}
+			_, err := gameSource.Create(Game{Rules: []game.RuleSpec{{Name: "beer-pong"}}}, request)
+			Expect(err).To(HaveOccurred())
+
+			created, err := gameSource.Create(Game{Rules: rules}, request)
+			Expect(err).ToNot(HaveOccurred())
+			stored := created.Result().(Game)
+
+			By("starting it")
+			Expect(gameSource.connection.Collection("game").Collection().UpdateId(stored.ID, bson.M{"$set": bson.M{"state": GameStateRunning}})).To(Succeed())
+
+			stored.State = GameStateRunning
+			stored.Rules = nil
+			updated, err := gameSource.Update(stored, request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Result().(Game).Rules).To(Equal(rules))
+		})
+
+		AfterEach(func() {
+			if con, err := bongo.Connect(getDatabaseConfiguration()); err == nil {
+				con.Session.DB(testDatabase).DropDatabase()
+			}
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/manyminds/api2go"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//guestUsernamePrefix namespaces the usernames of guests, so they never
+//collide with the unique username index or with registered users
+const guestUsernamePrefix = "guest:"
+
+//isGuestUsername checks if the name is reserved for guests
+func isGuestUsername(username string) bool {
+	return strings.HasPrefix(username, guestUsernamePrefix)
+}
+
+//hashDeviceToken returns the hash we store instead of the device token
+func hashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//newGuest returns a guest user with its device token,
+//the token is only handed out once and never stored in plain text
+func newGuest(nickname string) (User, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return User{}, "", err
+	}
+
+	token := base64.URLEncoding.EncodeToString(secret)
+	id := bson.NewObjectId()
+	user := User{
+		ID:              id,
+		Username:        guestUsernamePrefix + id.Hex(),
+		Nickname:        nickname,
+		Guest:           true,
+		DeviceTokenHash: hashDeviceToken(token),
+	}
+
+	return user, token, nil
+}
+
+//CheckDeviceToken compares the token with the stored hash
+func (u User) CheckDeviceToken(token string) bool {
+	if u.DeviceTokenHash == "" || token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(u.DeviceTokenHash), []byte(hashDeviceToken(token))) == 1
+}
+
+//guestRequest is posted to create a guest or to log in a known device
+type guestRequest struct {
+	Nickname    string `json:"nickname"`
+	DeviceToken string `json:"deviceToken"`
+}
+
+//readGuestRequest decodes the posted guest request
+func readGuestRequest(r *http.Request) (guestRequest, error) {
+	var input guestRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return input, api2go.NewHTTPError(err, "Invalid guest given", http.StatusBadRequest)
+	}
+
+	input.Nickname = strings.TrimSpace(input.Nickname)
+
+	return input, nil
+}
+
+//guest creates a guest user that only has a nickname
+func (s sessionHandler) guest(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	input, err := readGuestRequest(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if input.Nickname == "" {
+		writeError(w, api2go.NewHTTPError(nil, "A nickname is required", http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, deviceToken, err := newGuest(input.Nickname)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.connection.Collection("user").Save(&user); err != nil {
+		err = saveError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	s.respondWithSession(w, user, http.StatusCreated, map[string]interface{}{"deviceToken": deviceToken})
+}
+
+//device hands out a new session token to a guest that knows its device token
+func (s sessionHandler) device(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	input, err := readGuestRequest(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := User{}
+	err = s.connection.Collection("user").FindOne(bson.M{"devicetokenhash": hashDeviceToken(input.DeviceToken)}, &user)
+	if err != nil || !user.CheckDeviceToken(input.DeviceToken) {
+		writeError(w, api2go.NewHTTPError(err, "Unknown device", http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	s.respondWithSession(w, user, http.StatusOK, nil)
+}
+
+//claim turns the logged in guest into a registered user, the id stays
+//the same so the guest keeps all games it played
+func (s sessionHandler) claim(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	userID, err := s.auth.Authenticate(r)
+	if err != nil {
+		writeError(w, api2go.NewHTTPError(err, err.Error(), http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	input, err := readCredentials(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if msg := checkCredentials(input); msg != "" {
+		writeError(w, api2go.NewHTTPError(nil, msg, http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user := User{}
+	if err := s.connection.Collection("user").FindById(userID, &user); err != nil {
+		writeError(w, api2go.NewHTTPError(err, "Unknown user", http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if !user.Guest {
+		writeError(w, api2go.NewHTTPError(nil, "Only guests can be claimed", http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	user.Username = input.Username
+	user.Guest = false
+	user.DeviceTokenHash = ""
+	if err := user.SetPassword(input.Password); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.connection.Collection("user").Save(&user); err != nil {
+		err = saveError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	s.respondWithSession(w, user, http.StatusOK, nil)
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Guest", func() {
+	It("Should create guests with a namespaced username", func() {
+		user, _, err := newGuest("Partyhat")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(user.Guest).To(BeTrue())
+		Expect(user.Nickname).To(Equal("Partyhat"))
+		Expect(user.Username).To(Equal(guestUsernamePrefix + user.ID.Hex()))
+		Expect(isGuestUsername(user.Username)).To(BeTrue())
+	})
+
+	It("Should give every guest its own username", func() {
+		first, _, _ := newGuest("Partyhat")
+		second, _, _ := newGuest("Partyhat")
+		Expect(first.Username).ToNot(Equal(second.Username))
+	})
+
+	It("Should only store the hash of the device token", func() {
+		user, token, err := newGuest("Partyhat")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(user.DeviceTokenHash).ToNot(Equal(token))
+		Expect(user.CheckDeviceToken(token)).To(BeTrue())
+		Expect(user.CheckDeviceToken("stolen")).To(BeFalse())
+		Expect(user.CheckDeviceToken("")).To(BeFalse())
+	})
+
+	It("Should not let registered users take guest names", func() {
+		Expect(checkCredentials(credentials{Username: "guest:abc", Password: "secret123"})).ToNot(BeEmpty())
+		Expect(checkCredentials(credentials{Username: "Partyhat", Password: "secret123"})).To(BeEmpty())
+	})
+
+	Context("claiming", func() {
+		var (
+			connection *bongo.Connection
+			auth       *Authenticator
+			guest      User
+		)
+
+		claim := func(userID bson.ObjectId, body string) *httptest.ResponseRecorder {
+			r, _ := http.NewRequest("POST", "/v1/auth/claim", strings.NewReader(body))
+			if userID.Valid() {
+				token, _ := auth.Sign(userID)
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			w := httptest.NewRecorder()
+			sessionHandler{connection: connection, auth: auth}.routes().ServeHTTP(w, r)
+			return w
+		}
+
+		BeforeEach(func() {
+			auth = NewAuthenticator("secret", DefaultTokenLifetime)
+		})
+
+		It("Should refuse requests without a session", func() {
+			Expect(claim("", `{"username":"Partyhat","password":"secret123"}`).Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		Context("with a database", func() {
+			BeforeEach(func() {
+				var err error
+				connection, err = bongo.Connect(getDatabaseConfiguration())
+				Expect(err).ToNot(HaveOccurred())
+
+				guest, _, err = newGuest("Partyhat")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(connection.Collection("user").Save(&guest)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				connection.Session.DB(testDatabase).DropDatabase()
+			})
+
+			It("Should turn the guest into a registered user with the same id", func() {
+				w := claim(guest.ID, `{"username":"Partyhat","password":"secret123"}`)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Body.String()).To(ContainSubstring(`"token"`))
+
+				claimed := User{}
+				Expect(connection.Collection("user").FindById(guest.ID, &claimed)).To(Succeed())
+				Expect(claimed.Username).To(Equal("Partyhat"))
+				Expect(claimed.Guest).To(BeFalse())
+				Expect(claimed.DeviceTokenHash).To(BeEmpty())
+				Expect(claimed.CheckPassword("secret123")).To(BeTrue())
+			})
+
+			It("Should refuse invalid credentials", func() {
+				for _, body := range []string{`{"username":"guest:abc","password":"secret123"}`, `{"username":"Partyhat","password":"short"}`, `nonsense`} {
+					Expect(claim(guest.ID, body).Code).To(Equal(http.StatusBadRequest))
+				}
+
+				unchanged := User{}
+				Expect(connection.Collection("user").FindById(guest.ID, &unchanged)).To(Succeed())
+				Expect(unchanged.Guest).To(BeTrue())
+			})
+
+			It("Should refuse unknown users", func() {
+				Expect(claim(bson.NewObjectId(), `{"username":"Partyhat","password":"secret123"}`).Code).To(Equal(http.StatusNotFound))
+			})
+
+			It("Should only claim guests", func() {
+				Expect(claim(guest.ID, `{"username":"Partyhat","password":"secret123"}`).Code).To(Equal(http.StatusOK))
+				Expect(claim(guest.ID, `{"username":"Partyhat2","password":"secret123"}`).Code).To(Equal(http.StatusConflict))
+			})
+
+			It("Should refuse usernames that are taken", func() {
+				Expect(connection.Collection("user").Collection().EnsureIndex(mgo.Index{Key: []string{"username"}, Unique: true})).To(Succeed())
+				taken := User{Username: "Partyhat"}
+				Expect(taken.SetPassword("secret123")).To(Succeed())
+				Expect(connection.Collection("user").Save(&taken)).To(Succeed())
+
+				Expect(claim(guest.ID, `{"username":"Partyhat","password":"secret123"}`).Code).To(Equal(http.StatusConflict))
+
+				unchanged := User{}
+				Expect(connection.Collection("user").FindById(guest.ID, &unchanged)).To(Succeed())
+				Expect(unchanged.Guest).To(BeTrue())
+			})
+		})
+	})
+})
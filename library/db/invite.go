@@ -0,0 +1,134 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/maxwellhealth/bongo"
+	"rsc.io/qr"
+)
+
+//joinPath is the route of the frontend that joins a game by its code
+const joinPath = "/join/"
+
+//qrBorder is the white margin around a qr code in modules
+const qrBorder = 4
+
+//inviteHandler serves the invite links of games as qr codes,
+//so players can join by scanning the screen of the host
+type inviteHandler struct {
+	connection *bongo.Connection
+	publicURL  string
+}
+
+//routes returns the router for all /v1/invites/ routes
+func (h inviteHandler) routes() http.Handler {
+	router := httprouter.New()
+	router.GET("/v1/invites/:code", h.invite)
+	router.GET("/v1/invites/:code/qr.png", h.png)
+	router.GET("/v1/invites/:code/qr.svg", h.svg)
+
+	return router
+}
+
+//inviteURL returns the deep link into the frontend, without a configured
+//public url it is built from the request
+func (h inviteHandler) inviteURL(r *http.Request, code string) string {
+	base := strings.TrimRight(h.publicURL, "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+
+		base = scheme + "://" + r.Host
+	}
+
+	return base + joinPath + code
+}
+
+//openGame returns the join code of a game that can still be joined
+func (h inviteHandler) openGame(code string) (string, error) {
+	game, err := gameByJoinCode(h.connection, code)
+	if err != nil {
+		return "", mapError(err)
+	}
+
+	if game.State == GameStateFinished {
+		return "", apiError(nil, http.StatusGone, "Game finished", "The game is already finished")
+	}
+
+	return game.JoinCode, nil
+}
+
+//qrCode encodes the invite link of a game
+func (h inviteHandler) qrCode(w http.ResponseWriter, r *http.Request, ps httprouter.Params) (*qr.Code, bool) {
+	code, err := h.openGame(ps.ByName("code"))
+	if err != nil {
+		writeError(w, err, statusOf(err))
+		return nil, false
+	}
+
+	qrCode, err := qr.Encode(h.inviteURL(r, code), qr.M)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return qrCode, true
+}
+
+//invite responds with the join code and the invite link
+func (h inviteHandler) invite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	code, err := h.openGame(ps.ByName("code"))
+	if err != nil {
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"joinCode": code, "url": h.inviteURL(r, code)})
+}
+
+//png responds with the qr code of the invite link as png image
+func (h inviteHandler) png(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	qrCode, ok := h.qrCode(w, r, ps)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(qrCode.PNG())
+}
+
+//svg responds with the qr code of the invite link as svg image
+func (h inviteHandler) svg(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	qrCode, ok := h.qrCode(w, r, ps)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(qrSVG(qrCode))
+}
+
+//qrSVG draws every black module as a square of one unit
+func qrSVG(code *qr.Code) []byte {
+	size := code.Size + 2*qrBorder
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(out, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(out, "M%d %dh1v1h-1z", x+qrBorder, y+qrBorder)
+			}
+		}
+	}
+
+	out.WriteString(`"/></svg>`)
+
+	return out.Bytes()
+}
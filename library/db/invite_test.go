@@ -0,0 +1,97 @@
+package db
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+	"rsc.io/qr"
+)
+
+var _ = Describe("Invite", func() {
+	It("Should link to the public url or the host of the request", func() {
+		request, _ := http.NewRequest("GET", "http://party.local:8800/api/v1/invites/BEER42", nil)
+		Expect(inviteHandler{}.inviteURL(request, "BEER42")).To(Equal("http://party.local:8800/join/BEER42"))
+
+		request.Header.Set("X-Forwarded-Proto", "https")
+		Expect(inviteHandler{}.inviteURL(request, "BEER42")).To(Equal("https://party.local:8800/join/BEER42"))
+
+		handler := inviteHandler{publicURL: "https://soyfr.example/"}
+		Expect(handler.inviteURL(request, "BEER42")).To(Equal("https://soyfr.example/join/BEER42"))
+	})
+
+	It("Should draw qr codes with a white border", func() {
+		code, err := qr.Encode("https://soyfr.example/join/BEER42", qr.M)
+		Expect(err).ToNot(HaveOccurred())
+
+		svg := string(qrSVG(code))
+		size := code.Size + 2*qrBorder
+		Expect(svg).To(HavePrefix("<svg"))
+		Expect(svg).To(ContainSubstring(`viewBox="0 0 ` + strconv.Itoa(size) + " " + strconv.Itoa(size) + `"`))
+		Expect(svg).To(ContainSubstring("M4 4h1v1h-1z"))
+		Expect(svg).To(HaveSuffix("</svg>"))
+	})
+
+	Context("serving invites", func() {
+		var (
+			connection *bongo.Connection
+			server     *httptest.Server
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			for code, state := range map[string]GameState{"BEER42": GameStateLobby, "SHOT77": GameStateFinished} {
+				game := Game{HostID: bson.NewObjectId(), JoinCode: code, State: state}
+				Expect(connection.Collection("game").Save(&game)).To(Succeed())
+			}
+
+			server = httptest.NewServer(inviteHandler{connection: connection, publicURL: "https://soyfr.example"}.routes())
+		})
+
+		get := func(path string) (*http.Response, string) {
+			response, err := http.Get(server.URL + path)
+			Expect(err).ToNot(HaveOccurred())
+			defer response.Body.Close()
+
+			body, err := ioutil.ReadAll(response.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return response, string(body)
+		}
+
+		It("Should answer the link of an open game", func() {
+			response, body := get("/v1/invites/beer42")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring(`"url":"https://soyfr.example/join/BEER42"`))
+
+			response, body = get("/v1/invites/BEER42/qr.png")
+			Expect(response.Header.Get("Content-Type")).To(Equal("image/png"))
+			Expect(strings.HasPrefix(body, "\x89PNG")).To(BeTrue())
+
+			response, body = get("/v1/invites/BEER42/qr.svg")
+			Expect(response.Header.Get("Content-Type")).To(Equal("image/svg+xml"))
+			Expect(body).To(HavePrefix("<svg"))
+		})
+
+		It("Should refuse unknown and finished games", func() {
+			response, _ := get("/v1/invites/NOPE99/qr.png")
+			Expect(response.StatusCode).To(Equal(http.StatusNotFound))
+
+			response, _ = get("/v1/invites/SHOT77/qr.svg")
+			Expect(response.StatusCode).To(Equal(http.StatusGone))
+		})
+
+		AfterEach(func() {
+			server.Close()
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//joinCodeWords are easy to say out loud and to type on a phone
+var joinCodeWords = []string{
+	"ACE", "BAR", "BEER", "BINGO", "CHEERS", "CHUG", "CIDER", "CLUB",
+	"COLA", "CORK", "DANCE", "DARE", "DICE", "DISCO", "FIZZ", "FOAM",
+	"GULP", "HONEY", "HOPS", "JOKER", "JUG", "KEG", "KING", "LAGER",
+	"LEMON", "LIME", "LUCKY", "MALT", "MANGO", "MINT", "MUG", "NACHO",
+	"PARTY", "PEACH", "PINT", "PIZZA", "PUB", "PUNCH", "QUEEN", "SALSA",
+	"SHOT", "SODA", "SPIN", "SWIG", "TACO", "TAPAS", "TOAST", "VOTE",
+}
+
+//joinCodeDigits leaves out 0 and 1, they look like O and I
+const joinCodeDigits = "23456789"
+
+//joinCodeAttempts is how often a free code is searched before codes
+//get one more digit
+const joinCodeAttempts = 5
+
+//maxJoinCodeDigits limits the length of generated codes
+const maxJoinCodeDigits = 4
+
+//joinCodeRandom is seeded once, so restarted servers do not hand out
+//the same codes in the same order
+var joinCodeRandom = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+//newJoinCode returns a random code like BEER42 players can use to find a game
+func newJoinCode(digits int) string {
+	joinCodeRandom.Lock()
+	defer joinCodeRandom.Unlock()
+
+	code := joinCodeWords[joinCodeRandom.Intn(len(joinCodeWords))]
+	for i := 0; i < digits; i++ {
+		code += string(joinCodeDigits[joinCodeRandom.Intn(len(joinCodeDigits))])
+	}
+
+	return code
+}
+
+//uniqueJoinCode returns a code that is not taken yet, codes get longer
+//if the short ones are used up
+func uniqueJoinCode(taken func(code string) (bool, error)) (string, error) {
+	for digits := 2; digits <= maxJoinCodeDigits; digits++ {
+		for attempt := 0; attempt < joinCodeAttempts; attempt++ {
+			code := newJoinCode(digits)
+			exists, err := taken(code)
+			if err != nil {
+				return "", err
+			}
+
+			if !exists {
+				return code, nil
+			}
+		}
+	}
+
+	return "", errors.New("No free join code was found")
+}
+
+//normalizeJoinCode accepts codes as players type them, for example
+//"beer 42" or "Beer-42"
+func normalizeJoinCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+//joinCodeFormat is what a normalized code chosen by a host must look like
+var joinCodeFormat = regexp.MustCompile(`^[A-Z0-9]{4,16}$`)
+
+//validJoinCode checks a normalized code chosen by a host
+func validJoinCode(code string) bool {
+	return joinCodeFormat.MatchString(code)
+}
+
+//joinCodeTaken checks if any game, even a finished one, uses the code
+func (s GameSource) joinCodeTaken(code string) (bool, error) {
+	count, err := s.connection.Collection("game").Collection().Find(bson.M{"joincode": code}).Count()
+	return count > 0, err
+}
+
+//checkJoinCode answers codes chosen by a host with 400 if they can not be
+//typed by players and with 409 if another game already uses them
+func (s GameSource) checkJoinCode(code string) error {
+	if !validJoinCode(code) {
+		return saveError(&bongo.ValidationError{Errors: []error{
+			fieldError{Field: "joinCode", Msg: "Must be 4 to 16 letters and digits"},
+		}})
+	}
+
+	taken, err := s.joinCodeTaken(code)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if taken {
+		err := apiError(nil, http.StatusConflict, "Already taken", "The joinCode is already taken")
+		err.Errors[0].Source = &api2go.ErrorSource{Pointer: "/data/attributes/joinCode"}
+		return err
+	}
+
+	return nil
+}
+
+//gameByJoinCode looks up the game of a code as a player typed it
+func gameByJoinCode(connection *bongo.Connection, code string) (Game, error) {
+	game := Game{}
+	err := connection.Collection("game").FindOne(bson.M{"joincode": normalizeJoinCode(code)}, &game)
+
+	return game, err
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Join codes", func() {
+	It("Should be a word followed by digits", func() {
+		for i := 0; i < 50; i++ {
+			Expect(newJoinCode(2)).To(MatchRegexp(`^[A-Z]{3,6}[2-9]{2}$`))
+		}
+	})
+
+	It("Should get longer if the short codes are taken", func() {
+		var tried []string
+		code, err := uniqueJoinCode(func(code string) (bool, error) {
+			tried = append(tried, code)
+			return len(tried) <= joinCodeAttempts, nil
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tried).To(HaveLen(joinCodeAttempts + 1))
+		Expect(code).To(MatchRegexp(`^[A-Z]+[2-9]{3}$`))
+	})
+
+	It("Should give up if every code is taken", func() {
+		_, err := uniqueJoinCode(func(code string) (bool, error) { return true, nil })
+		Expect(err).To(HaveOccurred())
+
+		_, err = uniqueJoinCode(func(code string) (bool, error) { return false, errors.New("offline") })
+		Expect(err).To(MatchError("offline"))
+	})
+
+	It("Should accept codes as players type them", func() {
+		Expect(normalizeJoinCode(" beer 42")).To(Equal("BEER42"))
+		Expect(normalizeJoinCode("Beer-42")).To(Equal("BEER42"))
+	})
+
+	It("Should only accept codes of hosts that players can type", func() {
+		Expect(validJoinCode(normalizeJoinCode("beer 42"))).To(BeTrue())
+		Expect(validJoinCode("B42")).To(BeFalse())
+		Expect(validJoinCode("BEER#42")).To(BeFalse())
+		Expect(validJoinCode("BEERBEERBEERBEER42")).To(BeFalse())
+	})
+})
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"os"
+
+	"github.com/manyminds/soyfr/library/config"
 	"github.com/maxwellhealth/bongo"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -8,12 +11,23 @@ import (
 	"testing"
 )
 
-func getDatabaseConfiguration() *bongo.Config {
-	//TODO configure via environment variables
-	return &bongo.Config{
-		ConnectionString: "localhost",
-		Database:         "soyfer_test",
+//testDatabase is dropped by the specs, it is never taken from the environment
+const testDatabase = "soyfer_test"
+
+//getConfiguration reads the connection from the environment like the
+//application does, so the specs can run against any mongo server
+func getConfiguration() config.Config {
+	conf := config.Default()
+	if err := conf.ReadEnv(os.Getenv); err != nil {
+		panic(err)
 	}
+
+	conf.Database = testDatabase
+	return conf
+}
+
+func getDatabaseConfiguration() *bongo.Config {
+	return getConfiguration().Bongo()
 }
 
 func TestLibrary(t *testing.T) {
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"github.com/manyminds/soyfr/library/migration"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//Migrations is the database scheme, new migrations are appended and
+//applied migrations must never be changed. The first ones were
+//mongeez changesets and keep their ids.
+var Migrations = []migration.Migration{
+	{
+		ID: "nwagensonner:userUniqueUsername",
+		Steps: []migration.Step{
+			{Collection: "user", Remove: bson.M{}},
+			{Collection: "user", Index: &mgo.Index{Key: []string{"username"}, Unique: true}},
+		},
+	},
+	{
+		ID: "manyminds:gameUniqueJoinCode",
+		Steps: []migration.Step{
+			{Collection: "game", Index: &mgo.Index{Key: []string{"joincode"}, Unique: true}},
+		},
+	},
+	{
+		ID: "manyminds:userDeviceToken",
+		Steps: []migration.Step{
+			{Collection: "user", Index: &mgo.Index{Key: []string{"devicetokenhash"}, Sparse: true}},
+		},
+	},
+	{
+		ID: "manyminds:deckUniqueName",
+		Steps: []migration.Step{
+			{Collection: "deck", Index: &mgo.Index{Key: []string{"name"}, Unique: true}},
+		},
+	},
+	{
+		ID: "manyminds:challengeDeck",
+		Steps: []migration.Step{
+			{Collection: "challenge", Index: &mgo.Index{Key: []string{"deckid"}}},
+		},
+	},
+	{
+		ID: "manyminds:drinkEventLedger",
+		Steps: []migration.Step{
+			{Collection: "drinkEvent", Index: &mgo.Index{Key: []string{"userid", "-created"}}},
+			{Collection: "drinkEvent", Index: &mgo.Index{Key: []string{"gameid", "-created"}}},
+		},
+	},
+	{
+		ID: "manyminds:auditEntryGame",
+		Steps: []migration.Step{
+			{Collection: "auditEntry", Index: &mgo.Index{Key: []string{"gameid", "-created"}}},
+		},
+	},
+	{
+		ID: "manyminds:chatMessageGame",
+		Steps: []migration.Step{
+			{Collection: "chatMessage", Index: &mgo.Index{Key: []string{"gameid", "-created"}}},
+		},
+	},
+	{
+		ID: "manyminds:broadcastCapped",
+		Steps: []migration.Step{
+			{Collection: broadcastCollection, Create: &mgo.CollectionInfo{Capped: true, MaxBytes: broadcastSize}},
+		},
+	},
+}
+
+//MigrationRunner returns a runner for the database of the connection
+func MigrationRunner(connection *bongo.Connection) migration.Runner {
+	return migration.Runner{
+		Database:   connection.Session.DB(connection.Config.Database),
+		Migrations: Migrations,
+	}
+}
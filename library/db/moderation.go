@@ -0,0 +1,318 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/julienschmidt/httprouter"
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//moderationRequest is sent by the host, UserID is the player the
+//action is about. Ban is only used by kick.
+type moderationRequest struct {
+	UserID string `json:"userId"`
+	Ban    bool   `json:"ban"`
+}
+
+//moderationError is answered with status over http and sent
+//with the moderation-failed event to sockets
+func moderationError(status int, msg string) error {
+	return apiError(nil, status, msg, "")
+}
+
+//errorMessage returns the title of a json api error or the error text
+func errorMessage(err error) string {
+	if httpErr, ok := err.(api2go.HTTPError); ok && len(httpErr.Errors) > 0 {
+		return httpErr.Errors[0].Title
+	}
+
+	return err.Error()
+}
+
+//moderator carries out the actions only the host of a game may do,
+//every action is audited
+type moderator struct {
+	connection *bongo.Connection
+	server     broadcaster
+	registry   *roomRegistry
+	turns      *turnTable
+	auth       *Authenticator
+	away       *awayPlayers
+	audit      auditLog
+}
+
+func newModerator(server broadcaster, connection *bongo.Connection, registry *roomRegistry, turns *turnTable, auth *Authenticator, away *awayPlayers) *moderator {
+	return &moderator{
+		connection: connection,
+		server:     server,
+		registry:   registry,
+		turns:      turns,
+		auth:       auth,
+		away:       away,
+		audit:      auditLog{connection: connection},
+	}
+}
+
+//hostedGame returns the game if actor is its host
+func (m *moderator) hostedGame(gameID, actor bson.ObjectId) (Game, error) {
+	return hostedGame(m.connection, gameID, actor)
+}
+
+//target returns the player an action is about, the host can not
+//moderate themselves
+func (m *moderator) target(game Game, actor bson.ObjectId, request moderationRequest) (bson.ObjectId, error) {
+	if !bson.IsObjectIdHex(request.UserID) {
+		return "", moderationError(http.StatusBadRequest, "Choose a player")
+	}
+
+	target := bson.ObjectIdHex(request.UserID)
+	if target == actor {
+		return "", moderationError(http.StatusBadRequest, "The host can not do that to themselves")
+	}
+
+	if !game.HasPlayer(target) {
+		return "", moderationError(http.StatusNotFound, "The user is not a player of this game")
+	}
+
+	return target, nil
+}
+
+//update changes the stored game
+func (m *moderator) update(game Game, change bson.M) error {
+	return mapError(m.connection.Collection("game").Collection().UpdateId(game.ID, change))
+}
+
+//apply carries out an action of the host and returns its audit entry
+func (m *moderator) apply(game Game, actor bson.ObjectId, action ModerationAction, request moderationRequest) (AuditEntry, error) {
+	var target bson.ObjectId
+	var err error
+
+	switch action {
+	case ModerationKick, ModerationBan, ModerationMute, ModerationUnmute, ModerationTransfer:
+		if target, err = m.target(game, actor, request); err != nil {
+			return AuditEntry{}, err
+		}
+	}
+
+	switch action {
+	case ModerationKick, ModerationBan:
+		if request.Ban {
+			action = ModerationBan
+		}
+
+		err = m.kick(game, target, action == ModerationBan)
+	case ModerationMute, ModerationUnmute:
+		err = m.mute(game, target, action == ModerationMute)
+	case ModerationSkip:
+		err = m.skip(game)
+	case ModerationPause:
+		err = m.pause(game)
+	case ModerationResume:
+		err = m.resume(game)
+	case ModerationTransfer:
+		err = m.transfer(game, target)
+	default:
+		err = moderationError(http.StatusNotFound, "Unknown action")
+	}
+
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	return m.audit.record(game.ID, actor, action, target), nil
+}
+
+//kick takes all sockets of the player out of the game room and the
+//player out of the game, a banned player can not join again
+func (m *moderator) kick(game Game, target bson.ObjectId, ban bool) error {
+	change := bson.M{"$pull": bson.M{"playerids": target}}
+	if ban {
+		change["$addToSet"] = bson.M{"bannedids": target}
+	}
+
+	if err := m.update(game, change); err != nil {
+		return err
+	}
+
+	room := roomName(game)
+	for _, member := range m.registry.membersOf(room, target.Hex()) {
+		m.registry.leave(member.SocketID)
+		if member.socket != nil {
+			member.socket.Leave(room)
+			member.socket.Emit("kicked", map[string]interface{}{"game": game.ID, "banned": ban})
+		}
+
+		m.server.BroadcastTo(room, "player-left", member)
+	}
+
+	//a kicked player that lost the connection must not get the seat back
+	m.away.back(room, target.Hex())
+	m.server.BroadcastTo(room, "presence", m.registry.list(room))
+	m.turns.left(room, target.Hex())
+
+	return nil
+}
+
+//mute hides or shows the chat messages of the player
+func (m *moderator) mute(game Game, target bson.ObjectId, muted bool) error {
+	change := bson.M{"$pull": bson.M{"mutedids": target}}
+	if muted {
+		change = bson.M{"$addToSet": bson.M{"mutedids": target}}
+	}
+
+	if err := m.update(game, change); err != nil {
+		return err
+	}
+
+	m.server.BroadcastTo(roomName(game), "player-muted", map[string]interface{}{"userId": target, "muted": muted})
+
+	return nil
+}
+
+//skip ends the current turn
+func (m *moderator) skip(game Game) error {
+	engine := m.turns.engine(roomName(game))
+	if engine == nil {
+		return moderationError(http.StatusConflict, "The game is not running")
+	}
+
+	if err := engine.Skip(); err != nil {
+		return moderationError(http.StatusConflict, err.Error())
+	}
+
+	return nil
+}
+
+//pause stops the clock of a running game
+func (m *moderator) pause(game Game) error {
+	engine := m.turns.engine(roomName(game))
+	if game.State != GameStateRunning || engine == nil {
+		return moderationError(http.StatusConflict, "Only a running game can be paused")
+	}
+
+	if err := engine.Pause(); err != nil {
+		return moderationError(http.StatusConflict, err.Error())
+	}
+
+	return m.update(game, bson.M{"$set": bson.M{"state": GameStatePaused}})
+}
+
+//resume continues a paused game
+func (m *moderator) resume(game Game) error {
+	engine := m.turns.engine(roomName(game))
+	if game.State != GameStatePaused || engine == nil {
+		return moderationError(http.StatusConflict, "The game is not paused")
+	}
+
+	if err := engine.Continue(); err != nil {
+		return moderationError(http.StatusConflict, err.Error())
+	}
+
+	return m.update(game, bson.M{"$set": bson.M{"state": GameStateRunning}})
+}
+
+//transfer makes the player the host of the game
+func (m *moderator) transfer(game Game, target bson.ObjectId) error {
+	if err := m.update(game, bson.M{"$set": bson.M{"hostid": target}}); err != nil {
+		return err
+	}
+
+	m.server.BroadcastTo(roomName(game), "host-changed", map[string]interface{}{"userId": target})
+
+	return nil
+}
+
+//fromSocket is called for the moderation events of a socket, the
+//socket must have joined the game it moderates
+func (m *moderator) fromSocket(so socketio.Socket, action ModerationAction, request moderationRequest) {
+	member, room, ok := m.registry.memberOf(so.Id())
+	if !ok || !bson.IsObjectIdHex(member.UserID) {
+		so.Emit("moderation-failed", "Join a game first")
+		return
+	}
+
+	actor := bson.ObjectIdHex(member.UserID)
+	game, err := m.hostedGame(roomGameID(room), actor)
+	if err == nil {
+		_, err = m.apply(game, actor, action, request)
+	}
+
+	if err != nil {
+		so.Emit("moderation-failed", errorMessage(err))
+	}
+}
+
+//routes returns the router for all /v1/moderation/ routes
+func (m *moderator) routes() http.Handler {
+	router := httprouter.New()
+	router.POST("/v1/moderation/games/:id/:action", m.action)
+	router.GET("/v1/moderation/games/:id/audit", m.entries)
+
+	return router
+}
+
+//authorize returns the game of the request if the user of its
+//session token is the host
+func (m *moderator) authorize(r *http.Request, ps httprouter.Params) (Game, bson.ObjectId, error) {
+	actor, err := m.auth.Authenticate(r)
+	if err != nil {
+		return Game{}, "", apiError(err, http.StatusUnauthorized, err.Error(), "")
+	}
+
+	gameID, err := parseID(ps.ByName("id"))
+	if err != nil {
+		return Game{}, "", err
+	}
+
+	game, err := m.hostedGame(gameID, actor)
+
+	return game, actor, err
+}
+
+//action carries out the action of the url, the body is optional
+func (m *moderator) action(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	game, actor, err := m.authorize(r, ps)
+	if err != nil {
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	request := moderationRequest{}
+	if r.ContentLength != 0 {
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, apiError(err, http.StatusBadRequest, "Invalid request", ""), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entry, err := m.apply(game, actor, ModerationAction(ps.ByName("action")), request)
+	if err != nil {
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"audit": entry})
+}
+
+//entries responds with the latest audit entries of the game
+func (m *moderator) entries(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	game, _, err := m.authorize(r, ps)
+	if err != nil {
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	entries, err := m.audit.entries(game.ID)
+	if err != nil {
+		err = mapError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"game": game.ID, "audit": entries})
+}
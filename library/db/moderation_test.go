@@ -0,0 +1,148 @@
+package db
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Moderation", func() {
+	It("Should only moderate games the socket joined", func() {
+		mod := newModerator(&roomRecorder{}, nil, newRoomRegistry(), nil, nil, newAwayPlayers(DefaultGracePeriod))
+		so := &emitRecorder{id: "1"}
+		mod.fromSocket(so, ModerationKick, moderationRequest{})
+
+		Expect(so.received()).To(Equal([]string{`["moderation-failed","Join a game first"]`}))
+	})
+
+	It("Should report the title of api errors", func() {
+		Expect(errorMessage(moderationError(http.StatusForbidden, "Only the host can do that"))).To(Equal("Only the host can do that"))
+	})
+
+	It("Should refuse requests without a session token", func() {
+		mod := newModerator(&roomRecorder{}, nil, newRoomRegistry(), nil, NewAuthenticator("secret", DefaultTokenLifetime), newAwayPlayers(DefaultGracePeriod))
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/moderation/games/"+bson.NewObjectId().Hex()+"/pause", nil)
+		mod.routes().ServeHTTP(w, r)
+
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	Context("moderating a game", func() {
+		var (
+			connection *bongo.Connection
+			server     *roomRecorder
+			registry   *roomRegistry
+			mod        *moderator
+			stored     Game
+			host       bson.ObjectId
+			player     bson.ObjectId
+			auth       *Authenticator
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			host, player = bson.NewObjectId(), bson.NewObjectId()
+			stored = Game{HostID: host, PlayerIDs: []bson.ObjectId{host, player}, JoinCode: newJoinCode(2), State: GameStateLobby}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+
+			server = &roomRecorder{}
+			registry = newRoomRegistry()
+			auth = NewAuthenticator("secret", DefaultTokenLifetime)
+			mod = newModerator(server, connection, registry, newTurnTable(server, connection, registry), auth, newAwayPlayers(DefaultGracePeriod))
+		})
+
+		reload := func() Game {
+			reloaded := Game{}
+			Expect(connection.Collection("game").FindById(stored.ID, &reloaded)).To(Succeed())
+			return reloaded
+		}
+
+		It("Should only let the host moderate", func() {
+			_, err := mod.hostedGame(stored.ID, player)
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			game, err := mod.hostedGame(stored.ID, host)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = mod.apply(game, host, ModerationKick, moderationRequest{UserID: host.Hex()})
+			Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Should kick and ban players and audit it", func() {
+			so := &emitRecorder{id: "guest"}
+			registry.join(roomName(stored), roomMember{SocketID: "guest", UserID: player.Hex(), socket: so})
+
+			entry, err := mod.apply(stored, host, ModerationKick, moderationRequest{UserID: player.Hex(), Ban: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.Action).To(Equal(ModerationBan))
+			Expect(registry.list(roomName(stored))).To(BeEmpty())
+			Expect(so.received()[0]).To(ContainSubstring(`"kicked"`))
+			Expect(server.messages).To(Equal([]string{"player-left", "presence"}))
+
+			reloaded := reload()
+			Expect(reloaded.HasPlayer(player)).To(BeFalse())
+			Expect(reloaded.IsBanned(player)).To(BeTrue())
+
+			entries, err := mod.audit.entries(stored.ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].TargetID).To(Equal(player))
+		})
+
+		It("Should mute players and hand over the host", func() {
+			entry, err := mod.apply(stored, host, ModerationMute, moderationRequest{UserID: player.Hex()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.Action).To(Equal(ModerationMute))
+			Expect(reload().IsMuted(player)).To(BeTrue())
+
+			By("muting again")
+			entry, err = mod.apply(stored, host, ModerationMute, moderationRequest{UserID: player.Hex()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.Action).To(Equal(ModerationMute))
+			Expect(reload().IsMuted(player)).To(BeTrue())
+
+			entry, err = mod.apply(stored, host, ModerationUnmute, moderationRequest{UserID: player.Hex()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entry.Action).To(Equal(ModerationUnmute))
+			Expect(reload().IsMuted(player)).To(BeFalse())
+
+			_, err = mod.apply(stored, host, ModerationTransfer, moderationRequest{UserID: player.Hex()})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reload().HostID).To(Equal(player))
+		})
+
+		It("Should refuse to pause a game that is not running", func() {
+			_, err := mod.apply(stored, host, ModerationPause, moderationRequest{})
+			Expect(statusOf(err)).To(Equal(http.StatusConflict))
+		})
+
+		It("Should serve actions and the audit log over http", func() {
+			token, _ := auth.Sign(host)
+			body := strings.NewReader(`{"userId": "` + player.Hex() + `"}`)
+			r, _ := http.NewRequest("POST", "/v1/moderation/games/"+stored.ID.Hex()+"/mute", body)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			mod.routes().ServeHTTP(w, r)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			r, _ = http.NewRequest("GET", "/v1/moderation/games/"+stored.ID.Hex()+"/audit", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w = httptest.NewRecorder()
+			mod.routes().ServeHTTP(w, r)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(ContainSubstring(`"action":"mute"`))
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
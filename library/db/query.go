@@ -0,0 +1,162 @@
+package db
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//defaultPageLimit is used when a list is requested without limit
+	defaultPageLimit = 50
+	//maxPageLimit is the largest page a client can request
+	maxPageLimit = 100
+)
+
+//listQuery describes which part of a collection a client requested
+type listQuery struct {
+	Filter bson.M
+	Sort   string
+	Offset int
+	Limit  int
+	After  bson.ObjectId
+}
+
+//queryParam returns the first value of a query parameter
+func queryParam(r api2go.Request, name string) string {
+	if values := r.QueryParams[name]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+//queryNumber parses a positive number from the query
+func queryNumber(r api2go.Request, name string, fallback int) (int, error) {
+	value := queryParam(r, name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, parameterError(name, "Must be a positive number")
+	}
+
+	return number, nil
+}
+
+//parseListQuery reads paging, sorting and prefix filters of a request,
+//sortable and filterable map public field names to document fields
+func parseListQuery(r api2go.Request, sortable, filterable map[string]string) (listQuery, error) {
+	var err error
+	query := listQuery{Filter: bson.M{}}
+
+	if query.Offset, err = queryNumber(r, "page[offset]", 0); err != nil {
+		return query, err
+	}
+
+	if query.Limit, err = queryNumber(r, "page[limit]", defaultPageLimit); err != nil {
+		return query, err
+	}
+
+	if query.Limit == 0 || query.Limit > maxPageLimit {
+		query.Limit = maxPageLimit
+	}
+
+	if after := queryParam(r, "page[after]"); after != "" {
+		if !bson.IsObjectIdHex(after) {
+			return query, parameterError("page[after]", "Invalid cursor")
+		}
+
+		query.After = bson.ObjectIdHex(after)
+	}
+
+	if sort := queryParam(r, "sort"); sort != "" {
+		field, ok := sortable[strings.TrimPrefix(sort, "-")]
+		if !ok {
+			return query, parameterError("sort", "Can not sort by "+sort)
+		}
+
+		query.Sort = field
+		if strings.HasPrefix(sort, "-") {
+			query.Sort = "-" + field
+		}
+	}
+
+	for name, field := range filterable {
+		prefix := queryParam(r, "filter["+name+"]")
+		if prefix != "" {
+			query.Filter[field] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+		}
+	}
+
+	return query, nil
+}
+
+//sortFields returns the mgo sort order, the id keeps the order stable
+func (q listQuery) sortFields() []string {
+	if q.Sort == "" {
+		return []string{"_id"}
+	}
+
+	if strings.HasPrefix(q.Sort, "-") {
+		return []string{q.Sort, "-_id"}
+	}
+
+	return []string{q.Sort, "_id"}
+}
+
+//cursorFilter extends the filter to all documents behind the cursor
+func (q listQuery) cursorFilter(collection *bongo.Collection) (bson.M, error) {
+	if q.After == "" {
+		return q.Filter, nil
+	}
+
+	operator := "$gt"
+	if strings.HasPrefix(q.Sort, "-") {
+		operator = "$lt"
+	}
+
+	after := bson.M{"_id": bson.M{operator: q.After}}
+	if q.Sort != "" {
+		field := strings.TrimPrefix(q.Sort, "-")
+		cursor := bson.M{}
+		err := collection.Collection().FindId(q.After).Select(bson.M{field: 1}).One(&cursor)
+		if err != nil {
+			return nil, parameterError("page[after]", "Unknown cursor")
+		}
+
+		after = bson.M{"$or": []bson.M{
+			{field: bson.M{operator: cursor[field]}},
+			{field: cursor[field], "_id": bson.M{operator: q.After}},
+		}}
+	}
+
+	filter := bson.M{"$and": []bson.M{q.Filter, after}}
+
+	return filter, nil
+}
+
+//find returns the result set for the requested page and the number of all
+//documents that match the filter
+func (q listQuery) find(collection *bongo.Collection) (*bongo.ResultSet, uint, error) {
+	total, err := collection.Collection().Find(q.Filter).Count()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	filter, err := q.cursorFilter(collection)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resultSet := collection.Find(filter)
+	resultSet.Query.Sort(q.sortFields()...).Skip(q.Offset).Limit(q.Limit)
+
+	return resultSet, uint(total), nil
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"github.com/manyminds/api2go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Query", func() {
+	request := func(params map[string][]string) api2go.Request {
+		return api2go.Request{QueryParams: params}
+	}
+
+	It("Should use the default page without parameters", func() {
+		query, err := parseListQuery(request(nil), userSortable, userFilterable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.Offset).To(Equal(0))
+		Expect(query.Limit).To(Equal(defaultPageLimit))
+		Expect(query.sortFields()).To(Equal([]string{"_id"}))
+	})
+
+	It("Should read offset and limit", func() {
+		query, err := parseListQuery(request(map[string][]string{
+			"page[offset]": {"20"},
+			"page[limit]":  {"10"},
+		}), userSortable, userFilterable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.Offset).To(Equal(20))
+		Expect(query.Limit).To(Equal(10))
+	})
+
+	It("Should cap the limit", func() {
+		query, err := parseListQuery(request(map[string][]string{"page[limit]": {"100000"}}), userSortable, userFilterable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.Limit).To(Equal(maxPageLimit))
+	})
+
+	It("Should refuse invalid numbers and cursors", func() {
+		_, err := parseListQuery(request(map[string][]string{"page[offset]": {"-1"}}), userSortable, userFilterable)
+		Expect(err).To(HaveOccurred())
+
+		_, err = parseListQuery(request(map[string][]string{"page[after]": {"nope"}}), userSortable, userFilterable)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should sort by known fields in both directions", func() {
+		query, err := parseListQuery(request(map[string][]string{"sort": {"-username"}}), userSortable, userFilterable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.sortFields()).To(Equal([]string{"-username", "-_id"}))
+
+		_, err = parseListQuery(request(map[string][]string{"sort": {"passwordHash"}}), userSortable, userFilterable)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should search by escaped prefix", func() {
+		query, err := parseListQuery(request(map[string][]string{"filter[username]": {"a.b"}}), userSortable, userFilterable)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.Filter).To(Equal(bson.M{"username": bson.RegEx{Pattern: `^a\.b`, Options: "i"}}))
+	})
+})
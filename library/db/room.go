@@ -0,0 +1,348 @@
+package db
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//broadcaster sends a message to every socket in a room,
+//it is satisfied by *socketio.Server
+type broadcaster interface {
+	BroadcastTo(room, message string, args ...interface{})
+}
+
+//roomName returns the socket.io room of a game
+func roomName(game Game) string {
+	return "game:" + game.ID.Hex()
+}
+
+//roomGameID returns the id of the game a room was named after
+func roomGameID(room string) bson.ObjectId {
+	hex := strings.TrimPrefix(room, "game:")
+	if !bson.IsObjectIdHex(hex) {
+		return ""
+	}
+
+	return bson.ObjectIdHex(hex)
+}
+
+//roomMember is a socket that joined a game room, a user can be
+//connected with more than one socket
+type roomMember struct {
+	SocketID string `json:"socketId"`
+	UserID   string `json:"userId"`
+	socket   socketio.Socket
+}
+
+//roomRegistry keeps track of which socket is in which game room,
+//a socket can only be in one game at a time
+type roomRegistry struct {
+	sync.Mutex
+	members map[string]map[string]roomMember
+	sockets map[string]string
+}
+
+func newRoomRegistry() *roomRegistry {
+	return &roomRegistry{
+		members: make(map[string]map[string]roomMember),
+		sockets: make(map[string]string),
+	}
+}
+
+//join adds the member to the room and returns the room it left before, if any
+func (r *roomRegistry) join(room string, member roomMember) string {
+	r.Lock()
+	defer r.Unlock()
+
+	previous := r.remove(member.SocketID)
+
+	if _, ok := r.members[room]; !ok {
+		r.members[room] = make(map[string]roomMember)
+	}
+
+	r.members[room][member.SocketID] = member
+	r.sockets[member.SocketID] = room
+
+	return previous
+}
+
+//leave removes the socket from its room and returns the room name
+func (r *roomRegistry) leave(socketID string) string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.remove(socketID)
+}
+
+//remove must be called with the lock held
+func (r *roomRegistry) remove(socketID string) string {
+	room, ok := r.sockets[socketID]
+	if !ok {
+		return ""
+	}
+
+	delete(r.sockets, socketID)
+	delete(r.members[room], socketID)
+	if len(r.members[room]) == 0 {
+		delete(r.members, room)
+	}
+
+	return room
+}
+
+//roomOf returns the room of the socket or an empty string
+func (r *roomRegistry) roomOf(socketID string) string {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.sockets[socketID]
+}
+
+//memberOf returns the member of a socket and its room
+func (r *roomRegistry) memberOf(socketID string) (roomMember, string, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	room, ok := r.sockets[socketID]
+	if !ok {
+		return roomMember{}, "", false
+	}
+
+	return r.members[room][socketID], room, true
+}
+
+//users returns the ids of all users in a room
+func (r *roomRegistry) users(room string) map[string]bool {
+	r.Lock()
+	defer r.Unlock()
+
+	users := make(map[string]bool)
+	for _, member := range r.members[room] {
+		users[member.UserID] = true
+	}
+
+	return users
+}
+
+//membersOf returns the sockets a user joined the room with
+func (r *roomRegistry) membersOf(room, userID string) []roomMember {
+	r.Lock()
+	defer r.Unlock()
+
+	members := []roomMember{}
+	for _, member := range r.members[room] {
+		if member.UserID == userID {
+			members = append(members, member)
+		}
+	}
+
+	sort.Sort(bySocketID(members))
+
+	return members
+}
+
+//counts returns the number of sockets in every room
+func (r *roomRegistry) counts() map[string]int {
+	r.Lock()
+	defer r.Unlock()
+
+	counts := make(map[string]int)
+	for room, members := range r.members {
+		counts[room] = len(members)
+	}
+
+	return counts
+}
+
+//rooms returns the names of all rooms with members
+func (r *roomRegistry) rooms() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	rooms := []string{}
+	for room := range r.members {
+		rooms = append(rooms, room)
+	}
+
+	sort.Strings(rooms)
+
+	return rooms
+}
+
+//list returns all members of a room ordered by socket id
+func (r *roomRegistry) list(room string) []roomMember {
+	r.Lock()
+	defer r.Unlock()
+
+	members := []roomMember{}
+	for _, member := range r.members[room] {
+		members = append(members, member)
+	}
+
+	sort.Sort(bySocketID(members))
+
+	return members
+}
+
+type bySocketID []roomMember
+
+func (m bySocketID) Len() int           { return len(m) }
+func (m bySocketID) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m bySocketID) Less(i, j int) bool { return m[i].SocketID < m[j].SocketID }
+
+//gameRooms places sockets into the room of the game they joined
+type gameRooms struct {
+	server     broadcaster
+	connection *bongo.Connection
+	registry   *roomRegistry
+	auth       *Authenticator
+	turns      *turnTable
+	chat       *chatRoom
+	away       *awayPlayers
+}
+
+//findGame looks up a game that can still be joined by its join code
+func (g *gameRooms) findGame(code string) (Game, error) {
+	return gameByJoinCode(g.connection, code)
+}
+
+//join is called when a socket sends the join event with a join code,
+//the user becomes a player of the game
+func (g *gameRooms) join(so socketio.Socket, code string) {
+	userID, err := g.auth.Authenticate(so.Request())
+	if err != nil {
+		so.Emit("join-failed", "Log in before joining a game")
+		return
+	}
+
+	game, err := g.findGame(code)
+	if err != nil {
+		log.Printf("socket %s could not join %s: %s\n", so.Id(), code, err)
+		so.Emit("join-failed", "Unknown join code")
+		return
+	}
+
+	g.enter(so, userID, game)
+}
+
+//reconnect is called for every new socket, a user that is away from
+//a game joins it again without sending the join code
+func (g *gameRooms) reconnect(so socketio.Socket) {
+	userID, err := g.auth.Authenticate(so.Request())
+	if err != nil {
+		return
+	}
+
+	room := g.away.roomOf(userID.Hex())
+	if room == "" {
+		return
+	}
+
+	game := Game{}
+	if err := g.connection.Collection("game").FindById(roomGameID(room), &game); err != nil {
+		log.Printf("socket %s could not rejoin %s: %s\n", so.Id(), room, err)
+		return
+	}
+
+	g.enter(so, userID, game)
+}
+
+//enter puts the socket into the room of the game, a player that was
+//away gets the seat back
+func (g *gameRooms) enter(so socketio.Socket, userID bson.ObjectId, game Game) {
+	if game.State == GameStateFinished {
+		so.Emit("join-failed", "The game is already finished")
+		return
+	}
+
+	if game.IsBanned(userID) {
+		so.Emit("join-failed", "You were banned from this game")
+		return
+	}
+
+	if !game.HasPlayer(userID) {
+		err := g.connection.Collection("game").Collection().UpdateId(game.ID, bson.M{"$addToSet": bson.M{"playerids": userID}})
+		if err != nil {
+			log.Printf("could not add %s to game %s: %s\n", userID.Hex(), game.ID.Hex(), err)
+			so.Emit("join-failed", "Could not join the game")
+			return
+		}
+
+		game.PlayerIDs = append(game.PlayerIDs, userID)
+	}
+
+	room := roomName(game)
+	if g.registry.roomOf(so.Id()) == room {
+		so.Emit("joined", game)
+		return
+	}
+
+	member := roomMember{SocketID: so.Id(), UserID: userID.Hex(), socket: so}
+	if previous := g.registry.join(room, member); previous != "" && previous != room {
+		so.Leave(previous)
+		g.server.BroadcastTo(previous, "player-left", member)
+		g.server.BroadcastTo(previous, "presence", g.registry.list(previous))
+	}
+
+	so.Join(room)
+	so.Emit("joined", game)
+	if g.away.back(room, member.UserID) {
+		so.BroadcastTo(room, "player-back", member)
+	} else {
+		so.BroadcastTo(room, "player-joined", member)
+	}
+
+	g.server.BroadcastTo(room, "presence", g.registry.list(room))
+	g.turns.joined(so, room, member.UserID)
+	g.chat.history(so, room)
+}
+
+//leave removes the socket from its game room, quit is true if the
+//player left on purpose and not because the connection was lost.
+//A player whose last socket lost the connection keeps the seat for
+//the grace period.
+func (g *gameRooms) leave(so socketio.Socket, quit bool) {
+	member, room, ok := g.registry.memberOf(so.Id())
+	if !ok {
+		return
+	}
+
+	g.registry.leave(so.Id())
+	so.Leave(room)
+	if quit {
+		g.server.BroadcastTo(room, "player-left", member)
+	}
+
+	g.server.BroadcastTo(room, "presence", g.registry.list(room))
+
+	if g.registry.users(room)[member.UserID] {
+		return
+	}
+
+	if quit {
+		g.turns.left(room, member.UserID)
+		return
+	}
+
+	until := g.away.away(room, member.UserID, func() {
+		g.expired(room, member.UserID)
+	})
+	g.server.BroadcastTo(room, "player-away", map[string]interface{}{"userId": member.UserID, "until": until})
+	g.chat.disconnected(room, member.UserID)
+}
+
+//expired takes a player that did not come back out of the game
+func (g *gameRooms) expired(room, userID string) {
+	if g.registry.users(room)[userID] {
+		return
+	}
+
+	g.server.BroadcastTo(room, "player-left", roomMember{UserID: userID})
+	g.turns.left(room, userID)
+}
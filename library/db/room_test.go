@@ -0,0 +1,221 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//joinRecorder is a socket with a session that remembers the rooms it joined
+type joinRecorder struct {
+	*emitRecorder
+	request *http.Request
+	rooms   []string
+}
+
+func (j *joinRecorder) Request() *http.Request {
+	return j.request
+}
+
+func (j *joinRecorder) Join(room string) error {
+	j.rooms = append(j.rooms, room)
+	return nil
+}
+
+func (j *joinRecorder) Leave(room string) error {
+	return nil
+}
+
+func (j *joinRecorder) BroadcastTo(room, message string, args ...interface{}) error {
+	return nil
+}
+
+var _ = Describe("Room", func() {
+	var registry *roomRegistry
+
+	BeforeEach(func() {
+		registry = newRoomRegistry()
+	})
+
+	It("Should name rooms after the game", func() {
+		game := Game{ID: bson.NewObjectId()}
+		Expect(roomName(game)).To(Equal("game:" + game.ID.Hex()))
+	})
+
+	It("Should keep members of different games apart", func() {
+		registry.join("game:a", roomMember{SocketID: "1"})
+		registry.join("game:a", roomMember{SocketID: "2"})
+		registry.join("game:b", roomMember{SocketID: "3"})
+
+		Expect(registry.list("game:a")).To(Equal([]roomMember{{SocketID: "1"}, {SocketID: "2"}}))
+		Expect(registry.list("game:b")).To(Equal([]roomMember{{SocketID: "3"}}))
+		Expect(registry.rooms()).To(Equal([]string{"game:a", "game:b"}))
+	})
+
+	It("Should move a socket that joins another game", func() {
+		registry.join("game:a", roomMember{SocketID: "1"})
+		previous := registry.join("game:b", roomMember{SocketID: "1"})
+
+		Expect(previous).To(Equal("game:a"))
+		Expect(registry.list("game:a")).To(BeEmpty())
+		Expect(registry.roomOf("1")).To(Equal("game:b"))
+	})
+
+	It("Should know the user of every socket", func() {
+		registry.join("game:a", roomMember{SocketID: "1", UserID: "anna"})
+		registry.join("game:a", roomMember{SocketID: "2", UserID: "anna"})
+		registry.join("game:a", roomMember{SocketID: "3", UserID: "ben"})
+
+		member, room, ok := registry.memberOf("2")
+		Expect(ok).To(BeTrue())
+		Expect(room).To(Equal("game:a"))
+		Expect(member.UserID).To(Equal("anna"))
+		Expect(registry.users("game:a")).To(Equal(map[string]bool{"anna": true, "ben": true}))
+
+		registry.leave("1")
+		Expect(registry.users("game:a")).To(HaveKey("anna"))
+	})
+
+	It("Should forget sockets that left", func() {
+		registry.join("game:a", roomMember{SocketID: "1"})
+
+		Expect(registry.leave("1")).To(Equal("game:a"))
+		Expect(registry.leave("1")).To(Equal(""))
+		Expect(registry.roomOf("1")).To(Equal(""))
+	})
+
+	It("Should find every socket of a user in a room", func() {
+		registry.join("game:a", roomMember{SocketID: "1", UserID: "anna"})
+		registry.join("game:a", roomMember{SocketID: "2", UserID: "anna"})
+		registry.join("game:b", roomMember{SocketID: "3", UserID: "anna"})
+
+		Expect(registry.membersOf("game:a", "anna")).To(HaveLen(2))
+		Expect(registry.membersOf("game:a", "ben")).To(BeEmpty())
+	})
+
+	Context("joining by code", func() {
+		var (
+			connection *bongo.Connection
+			auth       *Authenticator
+			rooms      *gameRooms
+			stored     Game
+			player     bson.ObjectId
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			auth = NewAuthenticator("secret", DefaultTokenLifetime)
+			server := &roomRecorder{}
+			rooms = &gameRooms{
+				server:     server,
+				connection: connection,
+				registry:   registry,
+				auth:       auth,
+				turns:      newTurnTable(server, connection, registry),
+				chat:       newChatRoom(server, connection, registry, nil),
+				away:       newAwayPlayers(time.Minute),
+			}
+
+			player = bson.NewObjectId()
+			stored = Game{HostID: bson.NewObjectId(), JoinCode: "BEER42", State: GameStateLobby}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+
+		socket := func(id string, userID bson.ObjectId) *joinRecorder {
+			return &joinRecorder{emitRecorder: &emitRecorder{id: id}, request: sessionRequest(auth, userID).PlainRequest}
+		}
+
+		reload := func() Game {
+			reloaded := Game{}
+			Expect(connection.Collection("game").FindById(stored.ID, &reloaded)).To(Succeed())
+			return reloaded
+		}
+
+		It("Should add the player and put the socket into the room", func() {
+			so := socket("1", player)
+			rooms.join(so, "beer42")
+
+			Expect(so.rooms).To(Equal([]string{roomName(stored)}))
+			Expect(so.received()[0]).To(HavePrefix(`["joined",`))
+			Expect(reload().PlayerIDs).To(Equal([]bson.ObjectId{player}))
+			Expect(registry.users(roomName(stored))).To(HaveKey(player.Hex()))
+		})
+
+		It("Should not add a player twice", func() {
+			rooms.join(socket("1", player), "BEER42")
+			rooms.join(socket("2", player), "BEER42")
+
+			Expect(reload().PlayerIDs).To(HaveLen(1))
+		})
+
+		It("Should refuse sockets without a session", func() {
+			so := &joinRecorder{emitRecorder: &emitRecorder{id: "1"}, request: &http.Request{Header: http.Header{}}}
+			rooms.join(so, "BEER42")
+
+			Expect(so.received()).To(Equal([]string{`["join-failed","Log in before joining a game"]`}))
+			Expect(reload().PlayerIDs).To(BeEmpty())
+		})
+
+		It("Should refuse unknown and invalid codes", func() {
+			for _, code := range []string{"SHOT77", "", "not a code"} {
+				so := socket("1", player)
+				rooms.join(so, code)
+
+				Expect(so.received()).To(Equal([]string{`["join-failed","Unknown join code"]`}))
+				Expect(so.rooms).To(BeEmpty())
+			}
+		})
+
+		It("Should refuse banned players", func() {
+			stored.BannedIDs = []bson.ObjectId{player}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+
+			so := socket("1", player)
+			rooms.join(so, "BEER42")
+
+			Expect(so.received()).To(Equal([]string{`["join-failed","You were banned from this game"]`}))
+			Expect(so.rooms).To(BeEmpty())
+			Expect(reload().PlayerIDs).To(BeEmpty())
+		})
+
+		It("Should refuse finished games", func() {
+			stored.State = GameStateFinished
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+
+			so := socket("1", player)
+			rooms.join(so, "BEER42")
+
+			Expect(so.received()).To(Equal([]string{`["join-failed","The game is already finished"]`}))
+			Expect(reload().PlayerIDs).To(BeEmpty())
+		})
+
+		It("Should let players join a running game", func() {
+			stored.State = GameStateRunning
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+
+			room := roomName(stored)
+			engine := game.NewEngine(stored.ID.Hex(), []string{stored.HostID.Hex()}, nil, roomBroadcast{}, game.Options{})
+			rooms.turns.engines[room] = engine
+
+			so := socket("1", player)
+			rooms.join(so, "BEER42")
+
+			Expect(so.rooms).To(Equal([]string{room}))
+			Expect(engine.State().Players).To(Equal([]string{stored.HostID.Hex(), player.Hex()}))
+			Expect(so.received()).To(ContainElement(HavePrefix(`["turn-state",`)))
+			Expect(reload().PlayerIDs).To(Equal([]bson.ObjectId{player}))
+		})
+	})
+})
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//remainingSips returns how many sips a player may still drink with the
+//sips of the last hour and of the current game, a negative number means
+//there is no limit. The hourly limit cools down as old sips leave the hour.
+func remainingSips(user User, lastHour, inGame int) int {
+	if user.WaterMode {
+		return 0
+	}
+
+	remaining := -1
+	for _, limit := range []struct{ max, drunk int }{
+		{user.SipsPerHourLimit, lastHour},
+		{user.SipsPerGameLimit, inGame},
+	} {
+		if limit.max == 0 {
+			continue
+		}
+
+		left := limit.max - limit.drunk
+		if left < 0 {
+			left = 0
+		}
+
+		if remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+
+	return remaining
+}
+
+//limitSips reduces sips to what is allowed
+func limitSips(allowed, sips int) int {
+	if allowed >= 0 && sips > allowed {
+		return allowed
+	}
+
+	return sips
+}
+
+//safeguard looks up the personal limits of players
+type safeguard struct {
+	connection *bongo.Connection
+	stats      drinkStats
+}
+
+func newSafeguard(connection *bongo.Connection) safeguard {
+	return safeguard{connection: connection, stats: drinkStats{connection: connection}}
+}
+
+//allowance returns how many sips a player may still drink in a game
+func (s safeguard) allowance(userID, gameID bson.ObjectId) (int, error) {
+	user := User{}
+	if err := s.connection.Collection("user").FindById(userID, &user); err != nil {
+		return 0, err
+	}
+
+	if user.WaterMode || (user.SipsPerHourLimit == 0 && user.SipsPerGameLimit == 0) {
+		return remainingSips(user, 0, 0), nil
+	}
+
+	lastHour, err := s.stats.sipsSince(bson.M{"userid": userID}, time.Now().Add(-time.Hour))
+	if err != nil {
+		return 0, err
+	}
+
+	inGame, err := s.stats.sipsSince(bson.M{"userid": userID, "gameid": gameID}, time.Time{})
+	if err != nil {
+		return 0, err
+	}
+
+	return remainingSips(user, lastHour, inGame), nil
+}
+
+//limit returns the allowance of a player, players whose limits can not
+//be checked are treated as capped
+func (s safeguard) limit(userID string, gameID bson.ObjectId) int {
+	if !bson.IsObjectIdHex(userID) {
+		return -1
+	}
+
+	allowed, err := s.allowance(bson.ObjectIdHex(userID), gameID)
+	if err != nil {
+		log.Printf("could not check the sip limit of %s: %s\n", userID, err)
+		return 0
+	}
+
+	return allowed
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Safeguard", func() {
+	It("Should not limit players without limits", func() {
+		Expect(remainingSips(User{}, 50, 50)).To(Equal(-1))
+		Expect(limitSips(-1, 5)).To(Equal(5))
+	})
+
+	It("Should allow nothing in water mode", func() {
+		Expect(remainingSips(User{WaterMode: true, SipsPerHourLimit: 10}, 0, 0)).To(BeZero())
+		Expect(limitSips(0, 5)).To(BeZero())
+	})
+
+	It("Should use the stricter limit", func() {
+		user := User{SipsPerHourLimit: 10, SipsPerGameLimit: 20}
+		Expect(remainingSips(user, 4, 4)).To(Equal(6))
+		Expect(remainingSips(user, 4, 17)).To(Equal(3))
+		Expect(limitSips(3, 5)).To(Equal(3))
+		Expect(limitSips(3, 2)).To(Equal(2))
+	})
+
+	It("Should never allow less than nothing", func() {
+		Expect(remainingSips(User{SipsPerHourLimit: 5}, 8, 0)).To(BeZero())
+		Expect(remainingSips(User{SipsPerGameLimit: 5}, 0, 8)).To(BeZero())
+	})
+
+	It("Should refuse limits out of range", func() {
+		errs := User{Username: "unittest", SipsPerHourLimit: -1, SipsPerGameLimit: maxSipLimit + 1}.Validate(nil)
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].(fieldError).Pointer()).To(Equal("/data/attributes/sipsPerHourLimit"))
+		Expect(errs[1].(fieldError).Pointer()).To(Equal("/data/attributes/sipsPerGameLimit"))
+	})
+})
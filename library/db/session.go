@@ -0,0 +1,176 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/api2go/jsonapi"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//minPasswordLength is the shortest password we accept on registration
+const minPasswordLength = 6
+
+//credentials are posted to login and register
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+//sessionHandler serves the login and registration routes
+type sessionHandler struct {
+	connection *bongo.Connection
+	auth       *Authenticator
+}
+
+//routes returns the router for all /v1/auth/ routes
+func (s sessionHandler) routes() http.Handler {
+	router := httprouter.New()
+	router.POST("/v1/auth/register", s.register)
+	router.POST("/v1/auth/login", s.login)
+	router.POST("/v1/auth/guest", s.guest)
+	router.POST("/v1/auth/device", s.device)
+	router.POST("/v1/auth/claim", s.claim)
+
+	return router
+}
+
+//readCredentials decodes the posted credentials
+func readCredentials(r *http.Request) (credentials, error) {
+	var input credentials
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return input, api2go.NewHTTPError(err, "Invalid credentials given", http.StatusBadRequest)
+	}
+
+	input.Username = strings.TrimSpace(input.Username)
+
+	return input, nil
+}
+
+//checkCredentials returns why the credentials can not be used for an account
+func checkCredentials(input credentials) string {
+	if input.Username == "" {
+		return "A username is required"
+	}
+
+	if isGuestUsername(input.Username) {
+		return "This username is reserved for guests"
+	}
+
+	if len(input.Password) < minPasswordLength {
+		return "The password is too short"
+	}
+
+	return ""
+}
+
+//respondWithSession writes the user and a new session token,
+//extra is added to the meta data of the response
+func (s sessionHandler) respondWithSession(w http.ResponseWriter, user User, status int, extra map[string]interface{}) {
+	data, err := jsonapi.Marshal(user)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	token, expires := s.auth.Sign(user.ID)
+	meta := map[string]interface{}{
+		"token":   token,
+		"expires": expires.Format(time.RFC3339),
+	}
+
+	for key, value := range extra {
+		meta[key] = value
+	}
+
+	data["meta"] = meta
+
+	result, err := json.Marshal(data)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(status)
+	w.Write(result)
+}
+
+//register creates a new user with a password and logs it in
+func (s sessionHandler) register(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	input, err := readCredentials(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if msg := checkCredentials(input); msg != "" {
+		writeError(w, api2go.NewHTTPError(nil, msg, http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user := User{Username: input.Username}
+	if err := user.SetPassword(input.Password); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.connection.Collection("user").Save(&user); err != nil {
+		err = saveError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	s.respondWithSession(w, user, http.StatusCreated, nil)
+}
+
+//login checks the credentials and hands out a session token
+func (s sessionHandler) login(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	input, err := readCredentials(r)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := User{}
+	err = s.connection.Collection("user").FindOne(bson.M{"username": input.Username}, &user)
+	if err != nil || !user.CheckPassword(input.Password) {
+		writeError(w, api2go.NewHTTPError(err, "Invalid username or password", http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	s.respondWithSession(w, user, http.StatusOK, nil)
+}
+
+//availability tells registration forms if a username can still be used,
+//the answer only has meta data
+func (s sessionHandler) availability(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	meta := map[string]interface{}{"username": username, "available": false}
+
+	if errs := validate(field("username", username, usernameRules...)); len(errs) > 0 {
+		meta["reason"] = errs[0].(fieldError).Msg
+	} else if isGuestUsername(username) {
+		meta["reason"] = "This username is reserved for guests"
+	} else {
+		count, err := s.connection.Collection("user").Collection().Find(bson.M{"username": username}).Count()
+		if err != nil {
+			err = mapError(err)
+			writeError(w, err, statusOf(err))
+			return
+		}
+
+		meta["available"] = count == 0
+		if count > 0 {
+			meta["reason"] = "The username is already taken"
+		}
+	}
+
+	writeMeta(w, meta)
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//leaderboardSize is the number of players on a leaderboard
+const leaderboardSize = 10
+
+//drinkTotals sums up the drink events of a player or game. SipsPerHour
+//counts the sips of the last hour, AverageSipsPerHour spreads all sips
+//over the time between the first and the last drink.
+type drinkTotals struct {
+	Sips               int            `json:"sips"`
+	Drinks             int            `json:"drinks"`
+	SipsPerHour        int            `json:"sipsPerHour"`
+	AverageSipsPerHour float64        `json:"averageSipsPerHour"`
+	ByReason           map[string]int `json:"byReason"`
+	First              *time.Time     `json:"first,omitempty"`
+	Last               *time.Time     `json:"last,omitempty"`
+}
+
+//reasonTotals is a group of the totals pipeline
+type reasonTotals struct {
+	Reason string    `bson:"_id"`
+	Sips   int       `bson:"sips"`
+	Drinks int       `bson:"drinks"`
+	First  time.Time `bson:"first"`
+	Last   time.Time `bson:"last"`
+}
+
+//leaderboardEntry is the sum of all drinks of a player
+type leaderboardEntry struct {
+	UserID   bson.ObjectId `json:"userId" bson:"_id"`
+	Username string        `json:"username" bson:"-"`
+	Nickname string        `json:"nickname" bson:"-"`
+	Sips     int           `json:"sips" bson:"sips"`
+	Drinks   int           `json:"drinks" bson:"drinks"`
+}
+
+//drinkStats aggregates the drink ledger
+type drinkStats struct {
+	connection *bongo.Connection
+}
+
+//sipsSince returns the sips of all events matching the filter since a point in time
+func (s drinkStats) sipsSince(match bson.M, since time.Time) (int, error) {
+	filter := bson.M{"created": bson.M{"$gte": since}}
+	for key, value := range match {
+		filter[key] = value
+	}
+
+	var result []struct {
+		Sips int `bson:"sips"`
+	}
+
+	err := s.connection.Collection("drinkEvent").Collection().Pipe([]bson.M{
+		{"$match": filter},
+		{"$group": bson.M{"_id": nil, "sips": bson.M{"$sum": "$sips"}}},
+	}).All(&result)
+
+	if err != nil || len(result) == 0 {
+		return 0, err
+	}
+
+	return result[0].Sips, nil
+}
+
+//totals sums up all events matching the filter
+func (s drinkStats) totals(match bson.M, now time.Time) (drinkTotals, error) {
+	totals := drinkTotals{ByReason: map[string]int{}}
+
+	var groups []reasonTotals
+	err := s.connection.Collection("drinkEvent").Collection().Pipe([]bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":    "$reason",
+			"sips":   bson.M{"$sum": "$sips"},
+			"drinks": bson.M{"$sum": 1},
+			"first":  bson.M{"$min": "$created"},
+			"last":   bson.M{"$max": "$created"},
+		}},
+	}).All(&groups)
+
+	if err != nil {
+		return totals, err
+	}
+
+	sumTotals(&totals, groups)
+
+	totals.SipsPerHour, err = s.sipsSince(match, now.Add(-time.Hour))
+
+	return totals, err
+}
+
+//sumTotals adds the groups of the totals pipeline to totals
+func sumTotals(totals *drinkTotals, groups []reasonTotals) {
+	for _, group := range groups {
+		totals.Sips += group.Sips
+		totals.Drinks += group.Drinks
+		totals.ByReason[group.Reason] = group.Sips
+
+		if totals.First == nil || group.First.Before(*totals.First) {
+			first := group.First
+			totals.First = &first
+		}
+
+		if totals.Last == nil || group.Last.After(*totals.Last) {
+			last := group.Last
+			totals.Last = &last
+		}
+	}
+
+	if totals.First == nil {
+		return
+	}
+
+	//a party that lasted less than an hour counts as one hour
+	hours := totals.Last.Sub(*totals.First).Hours()
+	if hours < 1 {
+		hours = 1
+	}
+
+	totals.AverageSipsPerHour = float64(totals.Sips) / hours
+}
+
+//leaderboard returns the players that drank the most sips
+func (s drinkStats) leaderboard(match bson.M, limit int) ([]leaderboardEntry, error) {
+	entries := []leaderboardEntry{}
+	err := s.connection.Collection("drinkEvent").Collection().Pipe([]bson.M{
+		{"$match": match},
+		{"$group": bson.M{"_id": "$userid", "sips": bson.M{"$sum": "$sips"}, "drinks": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Name: "sips", Value: -1}, {Name: "_id", Value: 1}}},
+		{"$limit": limit},
+	}).All(&entries)
+
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+
+	ids := make([]bson.ObjectId, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.UserID)
+	}
+
+	names := map[bson.ObjectId]User{}
+	user := User{}
+	resultSet := s.connection.Collection("user").Find(bson.M{"_id": bson.M{"$in": ids}})
+	for resultSet.Next(&user) {
+		names[user.ID] = user
+	}
+
+	for i := range entries {
+		entries[i].Username = names[entries[i].UserID].Username
+		entries[i].Nickname = names[entries[i].UserID].Nickname
+	}
+
+	return entries, resultSet.Error
+}
+
+//statsHandler serves the statistics of the drink ledger
+type statsHandler struct {
+	stats drinkStats
+}
+
+//routes returns the router for all /v1/stats/ routes
+func (h statsHandler) routes() http.Handler {
+	router := httprouter.New()
+	router.GET("/v1/stats/users/:id", h.user)
+	router.GET("/v1/stats/games/:id", h.game)
+	router.GET("/v1/stats/leaderboard", h.allTime)
+
+	return router
+}
+
+//user responds with the all-time totals of a player
+func (h statsHandler) user(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	userID, err := parseID(ps.ByName("id"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	totals, err := h.stats.totals(bson.M{"userid": userID}, time.Now())
+	if err != nil {
+		err = mapError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"user": userID, "totals": totals})
+}
+
+//game responds with the totals and the leaderboard of a game
+func (h statsHandler) game(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	gameID, err := parseID(ps.ByName("id"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	match := bson.M{"gameid": gameID}
+	totals, err := h.stats.totals(match, time.Now())
+	if err != nil {
+		err = mapError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	leaderboard, err := h.stats.leaderboard(match, leaderboardSize)
+	if err != nil {
+		err = mapError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"game": gameID, "totals": totals, "leaderboard": leaderboard})
+}
+
+//allTime responds with the leaderboard over all games
+func (h statsHandler) allTime(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	leaderboard, err := h.stats.leaderboard(bson.M{}, leaderboardSize)
+	if err != nil {
+		err = mapError(err)
+		writeError(w, err, statusOf(err))
+		return
+	}
+
+	writeMeta(w, map[string]interface{}{"leaderboard": leaderboard})
+}
+
+//writeMeta responds with a json api document that only has meta data
+func writeMeta(w http.ResponseWriter, meta map[string]interface{}) {
+	result, err := json.Marshal(map[string]interface{}{"meta": meta})
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.Write(result)
+}
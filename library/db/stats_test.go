@@ -0,0 +1,88 @@
+package db
+
+import (
+	"time"
+
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Stats", func() {
+	start := time.Date(2015, 8, 1, 20, 0, 0, 0, time.UTC)
+
+	Context("summing up", func() {
+		It("Should add up all reasons", func() {
+			totals := drinkTotals{ByReason: map[string]int{}}
+			sumTotals(&totals, []reasonTotals{
+				{Reason: "challenge", Sips: 10, Drinks: 4, First: start.Add(time.Hour), Last: start.Add(3 * time.Hour)},
+				{Reason: "vote", Sips: 6, Drinks: 2, First: start, Last: start.Add(4 * time.Hour)},
+			})
+
+			Expect(totals.Sips).To(Equal(16))
+			Expect(totals.Drinks).To(Equal(6))
+			Expect(totals.ByReason).To(Equal(map[string]int{"challenge": 10, "vote": 6}))
+			Expect(*totals.First).To(Equal(start))
+			Expect(*totals.Last).To(Equal(start.Add(4 * time.Hour)))
+			Expect(totals.AverageSipsPerHour).To(Equal(4.0))
+		})
+
+		It("Should count short parties as one hour", func() {
+			totals := drinkTotals{ByReason: map[string]int{}}
+			sumTotals(&totals, []reasonTotals{{Reason: "penalty", Sips: 3, Drinks: 1, First: start, Last: start}})
+			Expect(totals.AverageSipsPerHour).To(Equal(3.0))
+		})
+
+		It("Should leave empty totals alone", func() {
+			totals := drinkTotals{ByReason: map[string]int{}}
+			sumTotals(&totals, nil)
+			Expect(totals.First).To(BeNil())
+			Expect(totals.AverageSipsPerHour).To(BeZero())
+		})
+	})
+
+	Context("aggregating the ledger", func() {
+		var connection *bongo.Connection
+		var stats drinkStats
+		var anna, ben, gameID bson.ObjectId
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+			stats = drinkStats{connection: connection}
+			anna, ben, gameID = bson.NewObjectId(), bson.NewObjectId(), bson.NewObjectId()
+
+			ledger := drinkLedger{connection: connection}
+			Expect(ledger.record(anna, gameID, DrinkReasonChallenge, 3)).To(Succeed())
+			Expect(ledger.record(anna, gameID, DrinkReasonVote, 2)).To(Succeed())
+			Expect(ledger.record(ben, gameID, DrinkReasonPenalty, 1)).To(Succeed())
+			Expect(ledger.record(ben, bson.NewObjectId(), DrinkReasonChallenge, 7)).To(Succeed())
+		})
+
+		It("Should sum up the drinks of a player", func() {
+			totals, err := stats.totals(bson.M{"userid": anna}, time.Now())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(totals.Sips).To(Equal(5))
+			Expect(totals.SipsPerHour).To(Equal(5))
+			Expect(totals.ByReason).To(Equal(map[string]int{"challenge": 3, "vote": 2}))
+		})
+
+		It("Should rank the players of a game and of all time", func() {
+			leaderboard, err := stats.leaderboard(bson.M{"gameid": gameID}, leaderboardSize)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leaderboard).To(HaveLen(2))
+			Expect(leaderboard[0].UserID).To(Equal(anna))
+
+			leaderboard, err = stats.leaderboard(bson.M{}, leaderboardSize)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(leaderboard[0].UserID).To(Equal(ben))
+			Expect(leaderboard[0].Sips).To(Equal(8))
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
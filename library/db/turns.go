@@ -0,0 +1,324 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//roomBroadcast sends the events of an engine to its game room
+//and hands them to the server side listener
+type roomBroadcast struct {
+	server   broadcaster
+	room     string
+	listener func(room, event string, state game.State)
+}
+
+//Broadcast satisfies the game.Broadcaster interface
+func (b roomBroadcast) Broadcast(event string, state game.State) {
+	b.server.BroadcastTo(b.room, event, state)
+	b.listener(b.room, event, state)
+}
+
+//turnTable runs the turn engine of every running game
+type turnTable struct {
+	sync.Mutex
+	server     broadcaster
+	connection *bongo.Connection
+	registry   *roomRegistry
+	ledger     drinkLedger
+	guard      safeguard
+	clock      game.Clock
+	engines    map[string]*game.Engine
+}
+
+func newTurnTable(server broadcaster, connection *bongo.Connection, registry *roomRegistry) *turnTable {
+	return &turnTable{
+		server:     server,
+		connection: connection,
+		registry:   registry,
+		ledger:     drinkLedger{connection: connection},
+		guard:      newSafeguard(connection),
+		clock:      game.RealClock(),
+		engines:    make(map[string]*game.Engine),
+	}
+}
+
+//engine returns the engine of a room or nil
+func (t *turnTable) engine(room string) *game.Engine {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.engines[room]
+}
+
+//running returns the number of games with an engine
+func (t *turnTable) running() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.engines)
+}
+
+//loadDeck returns all challenges of a deck as the engine draws them
+func (t *turnTable) loadDeck(deckID bson.ObjectId) ([]game.Challenge, error) {
+	var deck []game.Challenge
+	challenge := Challenge{}
+	resultSet := t.connection.Collection("challenge").Find(bson.M{"deckid": deckID})
+	for resultSet.Next(&challenge) {
+		deck = append(deck, challenge.card())
+	}
+
+	if resultSet.Error != nil {
+		return nil, resultSet.Error
+	}
+
+	if len(deck) == 0 {
+		return nil, errors.New("The deck has no challenges")
+	}
+
+	return deck, nil
+}
+
+//setState stores a new lifecycle state of the game
+func (t *turnTable) setState(gameID bson.ObjectId, state GameState) error {
+	return t.connection.Collection("game").Collection().UpdateId(gameID, bson.M{"$set": bson.M{"state": state}})
+}
+
+//options are the engine options of a room with the house rules of the
+//game, every change of the turns is stored with the game
+func (t *turnTable) options(room string, rules game.Rules) game.Options {
+	gameID := roomGameID(room)
+
+	return game.Options{
+		Clock: t.clock,
+		Rules: rules,
+		Allowance: func(player string) int {
+			return t.guard.limit(player, gameID)
+		},
+		Persist: func(snapshot game.Snapshot) {
+			t.persist(room, snapshot)
+		},
+	}
+}
+
+//persist stores the turns of a running game, they are removed
+//once the game is finished
+func (t *turnTable) persist(room string, snapshot game.Snapshot) {
+	update := bson.M{"$set": bson.M{"turnstate": snapshot}}
+	if snapshot.State.Phase == game.PhaseFinished {
+		update = bson.M{"$unset": bson.M{"turnstate": ""}}
+	}
+
+	if err := t.connection.Collection("game").Collection().UpdateId(roomGameID(room), update); err != nil {
+		log.Printf("could not store the turns of %s: %s\n", room, err)
+	}
+}
+
+//hostedGame returns the game of the socket if its user is the host
+func (t *turnTable) hostedGame(so socketio.Socket) (Game, string, error) {
+	stored := Game{}
+	member, room, ok := t.registry.memberOf(so.Id())
+	if !ok {
+		return stored, "", errors.New("Join a game first")
+	}
+
+	if err := t.connection.Collection("game").FindById(roomGameID(room), &stored); err != nil {
+		return stored, "", err
+	}
+
+	if stored.HostID.Hex() != member.UserID {
+		return stored, "", errors.New("Only the host can do that")
+	}
+
+	return stored, room, nil
+}
+
+//start is called by the host to begin the first turn, all players
+//that are connected take part
+func (t *turnTable) start(so socketio.Socket) {
+	stored, room, err := t.hostedGame(so)
+	if err != nil {
+		so.Emit("turn-failed", err.Error())
+		return
+	}
+
+	if !stored.State.CanTransitionTo(GameStateRunning) {
+		so.Emit("turn-failed", "The game can not be started")
+		return
+	}
+
+	if !stored.DeckID.Valid() {
+		so.Emit("turn-failed", "Choose a deck before starting")
+		return
+	}
+
+	deck, err := t.loadDeck(stored.DeckID)
+	if err != nil {
+		so.Emit("turn-failed", err.Error())
+		return
+	}
+
+	connected := t.registry.users(room)
+	var players []string
+	for _, playerID := range stored.PlayerIDs {
+		if connected[playerID.Hex()] {
+			players = append(players, playerID.Hex())
+		}
+	}
+
+	if len(players) == 0 {
+		so.Emit("turn-failed", game.ErrNoPlayers.Error())
+		return
+	}
+
+	out := roomBroadcast{server: t.server, room: room, listener: t.handle}
+	engine := game.NewEngine(stored.ID.Hex(), players, deck, out, t.options(room, stored.HouseRules()))
+
+	t.Lock()
+	if _, running := t.engines[room]; running {
+		t.Unlock()
+		so.Emit("turn-failed", game.ErrAlreadyStarted.Error())
+		return
+	}
+
+	t.engines[room] = engine
+	t.Unlock()
+
+	if err := t.setState(stored.ID, GameStateRunning); err != nil {
+		log.Printf("could not start game %s: %s\n", stored.ID.Hex(), err)
+	}
+
+	//the lock is released, a deck without fitting challenges
+	//finishes the game right away
+	if err := engine.Start(); err != nil {
+		log.Printf("could not start game %s: %s\n", stored.ID.Hex(), err)
+	}
+}
+
+//done is sent by the current player after completing the challenge
+func (t *turnTable) done(so socketio.Socket) {
+	member, room, ok := t.registry.memberOf(so.Id())
+	engine := t.engine(room)
+	if !ok || engine == nil {
+		so.Emit("turn-failed", game.ErrNotRunning.Error())
+		return
+	}
+
+	if err := engine.Complete(member.UserID); err != nil {
+		so.Emit("turn-failed", err.Error())
+	}
+}
+
+//joined adds the user to a running game and sends the current turn,
+//so clients that reconnect in the middle of a turn can catch up
+func (t *turnTable) joined(so socketio.Socket, room, userID string) {
+	engine := t.engine(room)
+	if engine == nil {
+		return
+	}
+
+	engine.AddPlayer(userID)
+	so.Emit("turn-state", engine.State())
+}
+
+//left takes a user that quit out of the running game
+func (t *turnTable) left(room, userID string) {
+	if engine := t.engine(room); engine != nil {
+		engine.RemovePlayer(userID)
+	}
+}
+
+//handle is called for every event of an engine, the player drinks the sips
+//of a completed challenge and the same as a penalty if time ran out,
+//but never more than the personal limit allows. The sips of house rules
+//are handed out for every ended turn.
+func (t *turnTable) handle(room, event string, state game.State) {
+	switch event {
+	case game.EventGameFinished:
+		t.finished(room)
+	case game.EventTurnEnded:
+		t.ledger.effects(t.guard, roomGameID(room), state.Effects)
+		if state.Challenge == nil || !bson.IsObjectIdHex(state.Player) {
+			return
+		}
+
+		reason := DrinkReasonChallenge
+		switch state.Outcome {
+		case game.OutcomeSkipped:
+			return
+		case game.OutcomeTimeout:
+			reason = DrinkReasonPenalty
+		}
+
+		gameID := roomGameID(room)
+		sips := limitSips(t.guard.limit(state.Player, gameID), state.Challenge.Sips)
+		err := t.ledger.record(bson.ObjectIdHex(state.Player), gameID, reason, sips)
+		if err != nil {
+			log.Printf("could not record drink of %s: %s\n", state.Player, err)
+		}
+	}
+}
+
+//finished forgets the engine of a room and marks the game as finished
+func (t *turnTable) finished(room string) {
+	t.Lock()
+	delete(t.engines, room)
+	t.Unlock()
+
+	if err := t.setState(roomGameID(room), GameStateFinished); err != nil {
+		log.Printf("could not finish game of %s: %s\n", room, err)
+	}
+}
+
+//suspend stops all engines and stores their state with the game,
+//the games keep running and can be continued from that state
+func (t *turnTable) suspend() {
+	t.Lock()
+	engines := t.engines
+	t.engines = make(map[string]*game.Engine)
+	t.Unlock()
+
+	for room, engine := range engines {
+		t.persist(room, engine.Stop())
+	}
+}
+
+//resume continues the turns of all running and paused games that were stored,
+//a turn whose deadline passed while the server was down times out
+func (t *turnTable) resume() error {
+	stored := Game{}
+	query := bson.M{
+		"state":                 bson.M{"$in": []GameState{GameStateRunning, GameStatePaused}},
+		"turnstate.state.phase": game.PhaseTurn,
+	}
+	resultSet := t.connection.Collection("game").Find(query)
+	for resultSet.Next(&stored) {
+		room := roomName(stored)
+		deck, err := t.loadDeck(stored.DeckID)
+		if err != nil {
+			log.Printf("could not resume game %s: %s\n", stored.ID.Hex(), err)
+			continue
+		}
+
+		out := roomBroadcast{server: t.server, room: room, listener: t.handle}
+		engine := game.Restore(*stored.TurnState, deck, out, t.options(room, stored.HouseRules()))
+
+		t.Lock()
+		t.engines[room] = engine
+		t.Unlock()
+
+		if err := engine.Resume(); err != nil {
+			log.Printf("could not resume game %s: %s\n", stored.ID.Hex(), err)
+		}
+
+		stored = Game{}
+	}
+
+	return resultSet.Error
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"time"
+
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//roomRecorder keeps everything sent to a room
+type roomRecorder struct {
+	rooms    []string
+	messages []string
+}
+
+func (r *roomRecorder) BroadcastTo(room, message string, args ...interface{}) {
+	r.rooms = append(r.rooms, room)
+	r.messages = append(r.messages, message)
+}
+
+var _ = Describe("Turns", func() {
+	It("Should send engine events to the game room and report the end", func() {
+		server := &roomRecorder{}
+		var finished []string
+		out := roomBroadcast{server: server, room: "game:a", listener: func(room, event string, state game.State) {
+			if event == game.EventGameFinished {
+				finished = append(finished, room)
+			}
+		}}
+
+		engine := game.NewEngine("a", []string{"anna"}, []game.Challenge{{ID: "1"}}, out, game.Options{})
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.Complete("anna")).To(Succeed())
+		engine.Stop()
+
+		Expect(server.rooms).To(ConsistOf("game:a", "game:a", "game:a"))
+		Expect(server.messages).To(Equal([]string{game.EventTurnStarted, game.EventTurnEnded, game.EventGameFinished}))
+		Expect(finished).To(Equal([]string{"game:a"}))
+	})
+
+	It("Should hand challenges to the engine", func() {
+		challenge := Challenge{Text: "Drink", Category: "everyone", Sips: 2, MinPlayers: 2, Timer: 10}
+		card := challenge.card()
+		Expect(card.Text).To(Equal("Drink"))
+		Expect(card.Sips).To(Equal(2))
+		Expect(card.Timer).To(Equal(10))
+		Expect(challenge.Fits(1)).To(BeFalse())
+	})
+
+	Context("resuming after a restart", func() {
+		var (
+			connection *bongo.Connection
+			stored     Game
+			players    []string
+		)
+
+		BeforeEach(func() {
+			var err error
+			connection, err = bongo.Connect(getDatabaseConfiguration())
+			Expect(err).ToNot(HaveOccurred())
+
+			deckID := bson.NewObjectId()
+			for _, text := range []string{"Drink", "Sing"} {
+				challenge := Challenge{DeckID: deckID, Text: text, Sips: 1}
+				Expect(connection.Collection("challenge").Save(&challenge)).To(Succeed())
+			}
+
+			players = []string{bson.NewObjectId().Hex(), bson.NewObjectId().Hex()}
+			stored = Game{HostID: bson.ObjectIdHex(players[0]), DeckID: deckID, JoinCode: newJoinCode(2), State: GameStateRunning}
+			Expect(connection.Collection("game").Save(&stored)).To(Succeed())
+		})
+
+		It("Should continue the stored turn and keep storing it", func() {
+			table := newTurnTable(&roomRecorder{}, connection, newRoomRegistry())
+			room := roomName(stored)
+			engine := game.NewEngine(stored.ID.Hex(), players, nil, roomBroadcast{}, game.Options{})
+			snapshot := engine.Snapshot()
+			snapshot.State.Phase = game.PhaseTurn
+			snapshot.State.Turn = 3
+			snapshot.State.Player = players[1]
+			snapshot.State.Deadline = time.Now().Add(time.Minute)
+			table.persist(room, snapshot)
+
+			Expect(table.resume()).To(Succeed())
+			Expect(table.running()).To(Equal(1))
+			Expect(table.engine(room).State().Turn).To(Equal(3))
+
+			Expect(table.engine(room).Complete(players[1])).To(Succeed())
+			reloaded := Game{}
+			Expect(connection.Collection("game").FindById(stored.ID, &reloaded)).To(Succeed())
+			Expect(reloaded.TurnState.State.Turn).To(Equal(4))
+			Expect(reloaded.TurnState.State.Player).To(Equal(players[0]))
+			table.suspend()
+		})
+
+		It("Should open stored votes again with their ballots", func() {
+			booth := newVotingBooth(&roomRecorder{}, connection, newRoomRegistry())
+			round := &voteRound{
+				ID:       bson.NewObjectId(),
+				Question: "Who drinks?",
+				Options:  players,
+				Deadline: time.Now().Add(time.Minute),
+				room:     roomName(stored),
+				votes:    map[string]Vote{players[0]: {ID: bson.NewObjectId(), VoterID: players[0], Choice: players[1]}},
+			}
+			booth.persist(round)
+
+			Expect(booth.resume()).To(Succeed())
+			booth.Lock()
+			resumed := booth.rounds[roomName(stored)]
+			booth.Unlock()
+			Expect(resumed.ID).To(Equal(round.ID))
+			Expect(resumed.votes[players[0]].Choice).To(Equal(players[1]))
+
+			booth.close(roomName(stored), round.ID, "complete")
+			reloaded := Game{}
+			Expect(connection.Collection("game").FindById(stored.ID, &reloaded)).To(Succeed())
+			Expect(reloaded.OpenVote).To(BeNil())
+		})
+
+		AfterEach(func() {
+			connection.Session.DB(testDatabase).DropDatabase()
+		})
+	})
+})
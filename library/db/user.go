@@ -2,20 +2,33 @@ package db
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/manyminds/api2go"
 	"github.com/manyminds/soyfr/library/common"
 	"github.com/maxwellhealth/bongo"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/mgo.v2/bson"
 )
 
-//User is a generic database user
+//User is a generic database user, guests only have a nickname
+//until they claim their account with a username and password.
+//Players can limit the sips they drink per hour and per game,
+//zero means no limit, and they drink nothing at all in water mode.
+//Admins can change every deck, they are only made on the command line.
 type User struct {
-	ID           bson.ObjectId `bson:"_id"`
-	Username     string
-	PasswordHash string `json:"-"`
-	exists       bool
+	ID               bson.ObjectId `bson:"_id"`
+	Username         string
+	Nickname         string
+	Guest            bool
+	Admin            bool
+	SipsPerHourLimit int
+	SipsPerGameLimit int
+	WaterMode        bool
+	PasswordHash     string `json:"-"`
+	DeviceTokenHash  string `json:"-"`
+	exists           bool
 }
 
 //SetIsNew satisfies the document base
@@ -43,9 +56,67 @@ func (u *User) SetId(id bson.ObjectId) {
 	u.ID = id
 }
 
+//SetID to satisfy api2go interface
+func (u *User) SetID(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return fmt.Errorf("invalid id %s", id)
+	}
+
+	u.ID = bson.ObjectIdHex(id)
+	return nil
+}
+
+//SetPassword stores the bcrypt hash of the password
+func (u *User) SetPassword(password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+//CheckPassword compares the password with the stored hash
+func (u User) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+//maxNameLength is the longest username or nickname
+const maxNameLength = 64
+
+//usernameRules are also used to check if a username is available
+var usernameRules = []stringRule{required, maxLength(maxNameLength), printable}
+
+//maxSipLimit is the highest personal sip limit
+const maxSipLimit = 100
+
+//Validate satisfies the bongo validate hook, it is called on every save
+func (u User) Validate(c *bongo.Collection) []error {
+	errs := validate(
+		field("username", u.Username, usernameRules...),
+		field("nickname", u.Nickname, maxLength(maxNameLength), printable),
+	)
+
+	if err := between("sipsPerHourLimit", u.SipsPerHourLimit, 0, maxSipLimit); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := between("sipsPerGameLimit", u.SipsPerGameLimit, 0, maxSipLimit); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
 //UserSource for api2go
 type UserSource struct {
 	connection *bongo.Connection
+	auth       *Authenticator
 }
 
 //CreateUserSource returns a configured and connected user source
@@ -59,29 +130,62 @@ func CreateUserSource(config *bongo.Config) (*UserSource, error) {
 	return &UserSource{connection: connection}, nil
 }
 
-//FindAll satisfies api2go data source interface
-func (s UserSource) FindAll(r api2go.Request) (interface{}, error) {
-	var users []User
+//userSortable are the fields users can be sorted by
+var userSortable = map[string]string{"username": "username"}
+
+//userFilterable are the fields users can be searched by prefix
+var userFilterable = map[string]string{"username": "username"}
+
+//FindAll satisfies api2go data source interface, it returns one page
+//of users which can be continued with the page[after] cursor in the meta data
+func (s UserSource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	_, response, err := s.PaginatedFindAll(r)
+	return response, err
+}
+
+//PaginatedFindAll satisfies api2go paging interface, it supports
+//sort=username and filter[username] as prefix search
+func (s UserSource) PaginatedFindAll(r api2go.Request) (uint, api2go.Responder, error) {
+	users := []User{}
 	user := User{}
-	//TODO introduce paging
-	resultSet := s.connection.Collection("user").Find(bson.M{})
-	if resultSet.Error != nil {
-		return users, resultSet.Error
+
+	query, err := parseListQuery(r, userSortable, userFilterable)
+	if err != nil {
+		return 0, &common.Response{}, err
+	}
+
+	resultSet, total, err := query.find(s.connection.Collection("user"))
+	if err != nil {
+		return 0, &common.Response{}, mapError(err)
 	}
 
 	for resultSet.Next(&user) {
 		users = append(users, user)
 	}
 
-	return users, nil
+	if resultSet.Error != nil {
+		return 0, &common.Response{}, mapError(resultSet.Error)
+	}
+
+	meta := map[string]interface{}{"total": total}
+	if len(users) == query.Limit {
+		meta["next"] = users[len(users)-1].GetID()
+	}
+
+	return total, &common.Response{Res: users, Code: http.StatusOK, Meta: meta}, nil
 }
 
 //FindOne satisfies api2go data source interface
 func (s UserSource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	id, err := parseID(ID)
+	if err != nil {
+		return common.Response{}, err
+	}
+
 	user := User{}
-	err := s.connection.Collection("user").FindById(bson.ObjectIdHex(ID), &user)
+	err = s.connection.Collection("user").FindById(id, &user)
 
-	return common.Response{Res: user, Code: http.StatusOK}, err
+	return common.Response{Res: user, Code: http.StatusOK}, mapError(err)
 }
 
 //FindMultiple satifies api2go data source interface
@@ -91,14 +195,18 @@ func (s *UserSource) FindMultiple(IDs []string, r api2go.Request) (interface{},
 
 	var findQuery []bson.ObjectId
 
-	for _, s := range IDs {
-		findQuery = append(findQuery, bson.ObjectIdHex(s))
+	for _, ID := range IDs {
+		id, err := parseID(ID)
+		if err != nil {
+			return users, err
+		}
+
+		findQuery = append(findQuery, id)
 	}
 
-	//TODO introduce paging
 	resultSet := s.connection.Collection("user").Find(bson.M{"_id": bson.M{"$in": findQuery}})
 	if resultSet.Error != nil {
-		return users, resultSet.Error
+		return users, mapError(resultSet.Error)
 	}
 
 	for resultSet.Next(&user) {
@@ -115,10 +223,20 @@ func (s UserSource) Create(obj interface{}, r api2go.Request) (api2go.Responder,
 		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
 	}
 
+	if isGuestUsername(user.Username) {
+		return &common.Response{}, api2go.NewHTTPError(nil, "This username is reserved for guests", http.StatusBadRequest)
+	}
+
+	//passwords and guests are only created through the auth routes
+	user.PasswordHash = ""
+	user.DeviceTokenHash = ""
+	user.Guest = false
+	user.Admin = false
+	user.ID = bson.NewObjectId()
 	err := s.connection.Collection("user").Save(&user)
 
 	if err != nil {
-		return &common.Response{}, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+		return &common.Response{}, saveError(err)
 	}
 
 	return &common.Response{Res: user, Code: http.StatusCreated}, nil
@@ -136,13 +254,53 @@ func (s UserSource) Delete(id string, r api2go.Request) (api2go.Responder, error
 		return nil, errors.New("Invalid instance given")
 	}
 
-	s.connection.Collection("user").DeleteDocument(&user)
+	err = s.connection.Collection("user").DeleteDocument(&user)
+	if err != nil {
+		return nil, mapError(err)
+	}
 
 	return common.Response{Res: user, Code: http.StatusOK}, nil
 }
 
-//Update stores all changes on the user
+//Update stores all changes on the user, credentials can not be changed here
 func (s UserSource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
-	//create and update are the same method in a odm
-	return s.Create(obj, r)
+	user, ok := obj.(User)
+	if !ok {
+		return &common.Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+	}
+
+	//the id of the document wins over the id in the path
+	//checked by the guard, so it is checked again
+	actor, err := s.auth.requestUser(r)
+	if err != nil {
+		return &common.Response{}, err
+	}
+
+	if actor != user.ID {
+		return &common.Response{}, apiError(nil, http.StatusForbidden, "You can only change your own account", "")
+	}
+
+	stored := User{}
+	err = s.connection.Collection("user").FindById(user.ID, &stored)
+	if err != nil {
+		return &common.Response{}, mapError(err)
+	}
+
+	user.PasswordHash = stored.PasswordHash
+	user.DeviceTokenHash = stored.DeviceTokenHash
+	user.Guest = stored.Guest
+	user.Admin = stored.Admin
+	if stored.Guest {
+		//guests get a username by claiming their account
+		user.Username = stored.Username
+	} else if isGuestUsername(user.Username) {
+		return &common.Response{}, api2go.NewHTTPError(nil, "This username is reserved for guests", http.StatusBadRequest)
+	}
+
+	err = s.connection.Collection("user").Save(&user)
+	if err != nil {
+		return &common.Response{}, saveError(err)
+	}
+
+	return &common.Response{Res: user, Code: http.StatusOK}, nil
 }
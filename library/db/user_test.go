@@ -20,6 +20,7 @@ import (
 var _ = Describe("User", func() {
 	var userSource *UserSource
 	var request api2go.Request
+	var auth *Authenticator
 
 	requestGET := func(URL string) (string, int) {
 		resp, err := http.Get(URL)
@@ -44,12 +45,14 @@ var _ = Describe("User", func() {
 		var err error
 		userSource, err = CreateUserSource(getDatabaseConfiguration())
 		Expect(err).ToNot(HaveOccurred())
+		auth = NewAuthenticator("secret", DefaultTokenLifetime)
+		userSource.auth = auth
 	})
 
 	Context("test crud via api", func() {
 		var server *httptest.Server
 		BeforeEach(func() {
-			server = httptest.NewServer((BootstrapAPI(getDatabaseConfiguration())))
+			server = httptest.NewServer((BootstrapAPI(userSource.connection, auth, "")))
 		})
 
 		PIt("Should be able to list users", func() {
@@ -58,6 +61,50 @@ var _ = Describe("User", func() {
 			Expect(body).To(MatchJSON("{\"data\":[]}"))
 		})
 
+		It("Should tell if a username is available", func() {
+			body, status := requestGET(server.URL + "/v1/users/availability?username=Unittest")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(MatchJSON(`{"meta":{"username":"Unittest","available":true}}`))
+
+			_, err := userSource.Create(User{Username: "Unittest"}, request)
+			Expect(err).ToNot(HaveOccurred())
+
+			body, status = requestGET(server.URL + "/v1/users/availability?username=Unittest")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring(`"available":false`))
+
+			body, _ = requestGET(server.URL + "/v1/users/availability?username=")
+			Expect(body).To(ContainSubstring(`"available":false`))
+		})
+
+		It("Should let players set their own sip limits", func() {
+			created, err := userSource.Create(User{Username: "Unittest"}, request)
+			Expect(err).ToNot(HaveOccurred())
+			id := created.Result().(User).GetID()
+
+			patch := func(token string) int {
+				data := `{"data": {"id": "` + id + `", "type": "users", "attributes": {"username": "Unittest", "sipsPerHourLimit": 5, "sipsPerGameLimit": 10, "waterMode": true}}}`
+				r, _ := http.NewRequest("PATCH", server.URL+"/v1/users/"+id, strings.NewReader(data))
+				r.Header.Set("Authorization", "Bearer "+token)
+				resp, err := http.DefaultClient.Do(r)
+				Expect(err).ToNot(HaveOccurred())
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+
+			stranger, _ := auth.Sign(bson.NewObjectId())
+			Expect(patch(stranger)).To(Equal(http.StatusForbidden))
+
+			token, _ := auth.Sign(bson.ObjectIdHex(id))
+			Expect(patch(token)).To(Equal(http.StatusOK))
+
+			stored := User{}
+			Expect(userSource.connection.Collection("user").FindById(bson.ObjectIdHex(id), &stored)).To(Succeed())
+			Expect(stored.SipsPerHourLimit).To(Equal(5))
+			Expect(stored.SipsPerGameLimit).To(Equal(10))
+			Expect(stored.WaterMode).To(BeTrue())
+		})
+
 		PIt("Should be able to create a new user", func() {
 			data := `
 				{
@@ -80,13 +127,14 @@ var _ = Describe("User", func() {
 		It("Should create a new user", func() {
 			By("storing it")
 			user := User{Username: "Unittest"}
-			id, err := userSource.Create(user, request)
+			created, err := userSource.Create(user, request)
 			Expect(err).ToNot(HaveOccurred())
+			id := created.Result().(User).GetID()
 			Expect(id).ToNot(Equal(""))
 			By("finding it again")
 			after, err := userSource.FindOne(id, request)
 			Expect(err).ToNot(HaveOccurred())
-			castedUser, ok := after.(User)
+			castedUser, ok := after.Result().(User)
 			Expect(ok).To(Equal(true))
 			Expect(id).To(Equal(castedUser.GetId().Hex()))
 		})
@@ -94,30 +142,57 @@ var _ = Describe("User", func() {
 		It("Should create a new user and update him", func() {
 			By("storing it")
 			user := User{Username: "Unittest"}
-			id, err := userSource.Create(user, request)
+			created, err := userSource.Create(user, request)
 			Expect(err).ToNot(HaveOccurred())
+			id := created.Result().(User).GetID()
 			Expect(id).ToNot(Equal(""))
 			user.ID = bson.ObjectIdHex(id)
 
 			By("renaming him")
 			user.Username = "New Unittest"
-			err = userSource.Update(user, request)
+			_, err = userSource.Update(user, sessionRequest(auth, user.ID))
 			Expect(err).ToNot(HaveOccurred())
 
 			By("retrieving him from the database")
 			after, err := userSource.FindOne(id, request)
 			Expect(err).ToNot(HaveOccurred())
-			castedUser, ok := after.(User)
+			castedUser, ok := after.Result().(User)
 			Expect(ok).To(Equal(true))
 			Expect(id).To(Equal(castedUser.GetId().Hex()))
 			Expect(castedUser.Username).To(Equal("New Unittest"))
 		})
 
+		It("Should not rename users into the guest namespace", func() {
+			created, err := userSource.Create(User{Username: "Unittest"}, request)
+			Expect(err).ToNot(HaveOccurred())
+			user := created.Result().(User)
+
+			user.Username = "guest:Unittest"
+			_, err = userSource.Update(user, sessionRequest(auth, user.ID))
+			Expect(statusOf(err)).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Should update the sip limits and only of the logged in user", func() {
+			created, err := userSource.Create(User{Username: "Unittest"}, request)
+			Expect(err).ToNot(HaveOccurred())
+			user := created.Result().(User)
+
+			user.SipsPerHourLimit = 4
+			user.WaterMode = true
+			_, err = userSource.Update(user, sessionRequest(auth, bson.NewObjectId()))
+			Expect(statusOf(err)).To(Equal(http.StatusForbidden))
+
+			updated, err := userSource.Update(user, sessionRequest(auth, user.ID))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Result().(User).SipsPerHourLimit).To(Equal(4))
+			Expect(updated.Result().(User).WaterMode).To(BeTrue())
+		})
+
 		It("Should find zero users", func() {
 			resultSet, err := userSource.FindAll(request)
 			Expect(err).ToNot(HaveOccurred())
 
-			data, ok := resultSet.([]User)
+			data, ok := resultSet.Result().([]User)
 			Expect(ok).To(Equal(true))
 			Expect(data).To(HaveLen(0))
 		})
@@ -133,7 +208,7 @@ var _ = Describe("User", func() {
 			resultSet, err := userSource.FindAll(request)
 			Expect(err).ToNot(HaveOccurred())
 
-			data, ok := resultSet.([]User)
+			data, ok := resultSet.Result().([]User)
 			Expect(ok).To(Equal(true))
 			Expect(data).To(HaveLen(3))
 		})
@@ -146,8 +221,9 @@ var _ = Describe("User", func() {
 			for i < maxUsers {
 				i++
 				user := User{Username: fmt.Sprintf("user_%d", i)}
-				idString, err := userSource.Create(user, request)
+				created, err := userSource.Create(user, request)
 				Expect(err).ToNot(HaveOccurred())
+				idString := created.Result().(User).GetID()
 
 				if rand.Int()%2 == 0 {
 					idsToFind = append(idsToFind, idString)
@@ -163,11 +239,86 @@ var _ = Describe("User", func() {
 			Expect(ok).To(Equal(true))
 			Expect(data).To(HaveLen(len(idsToFind)))
 		})
+
+		It("Should answer unknown and malformed ids with errors", func() {
+			_, err := userSource.FindOne(bson.NewObjectId().Hex(), request)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("404"))
+
+			_, err = userSource.FindOne("not-an-id", request)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("400"))
+
+			_, err = userSource.Delete(bson.NewObjectId().Hex(), request)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("404"))
+		})
+	})
+
+	Context("paging users", func() {
+		BeforeEach(func() {
+			for _, username := range []string{"carl", "anna", "ben", "annika", "dave"} {
+				_, err := userSource.Create(User{Username: username}, request)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		usernames := func(response api2go.Responder) []string {
+			var names []string
+			for _, user := range response.Result().([]User) {
+				names = append(names, user.Username)
+			}
+
+			return names
+		}
+
+		It("Should return a page with the total count", func() {
+			pageRequest := api2go.Request{QueryParams: map[string][]string{
+				"page[offset]": {"1"},
+				"page[limit]":  {"2"},
+				"sort":         {"username"},
+			}}
+
+			total, response, err := userSource.PaginatedFindAll(pageRequest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(Equal(uint(5)))
+			Expect(usernames(response)).To(Equal([]string{"annika", "ben"}))
+			Expect(response.Metadata()).To(HaveKeyWithValue("total", uint(5)))
+		})
+
+		It("Should continue after the cursor", func() {
+			firstPage, err := userSource.FindAll(api2go.Request{QueryParams: map[string][]string{
+				"page[limit]": {"3"},
+				"sort":        {"-username"},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usernames(firstPage)).To(Equal([]string{"dave", "carl", "ben"}))
+
+			next := firstPage.Metadata()["next"].(string)
+			secondPage, err := userSource.FindAll(api2go.Request{QueryParams: map[string][]string{
+				"page[limit]": {"3"},
+				"page[after]": {next},
+				"sort":        {"-username"},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usernames(secondPage)).To(Equal([]string{"annika", "anna"}))
+			Expect(secondPage.Metadata()).ToNot(HaveKey("next"))
+		})
+
+		It("Should search users by prefix", func() {
+			response, err := userSource.FindAll(api2go.Request{QueryParams: map[string][]string{
+				"filter[username]": {"ANN"},
+				"sort":             {"username"},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(usernames(response)).To(Equal([]string{"anna", "annika"}))
+			Expect(response.Metadata()).To(HaveKeyWithValue("total", uint(2)))
+		})
 	})
 
 	AfterEach(func() {
 		if con, err := bongo.Connect(getDatabaseConfiguration()); err == nil {
-			con.Session.DB("soyfer_test").DropDatabase()
+			con.Session.DB(testDatabase).DropDatabase()
 		}
 	})
 })
@@ -3,70 +3,192 @@ package db
 import (
 	"log"
 	"net/http"
-	"os"
-	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/googollee/go-socket.io"
 	"github.com/manyminds/api2go"
 	"github.com/maxwellhealth/bongo"
 )
 
-const (
-	//DockerConnectionString this string connects to a docker link TODO normal configuration
-	DockerConnectionString = "MONGODB_PORT_27017_TCP"
-	//DirectConnectionString is used when connecting without docker
-	DirectConnectionString = "MONGO_CONNECTION_STRING"
-	//FallbackConnectionString is the one we use if everthing else fails
-	FallbackConnectionString = "localhost:27017"
-)
+//BootstrapAPI returns the json api handler, all routes that change data
+//require a session token signed by auth. The connection is shared by
+//all requests and must stay open as long as the handler is used.
+//Invite links point to publicURL or the host of the request if it is empty.
+func BootstrapAPI(connection *bongo.Connection, auth *Authenticator, publicURL string) http.Handler {
+	api := api2go.NewAPI("v1")
+	api.AddResource(User{}, UserSource{connection: connection, auth: auth})
+	api.AddResource(Game{}, GameSource{connection: connection, auth: auth})
+	api.AddResource(Deck{}, DeckSource{connection: connection, auth: auth})
+	api.AddResource(Challenge{}, ChallengeSource{connection: connection, auth: auth})
+	api.AddResource(DrinkEvent{}, DrinkEventSource{connection: connection, auth: auth})
 
-//GetConnectionString returns the configured connection string
-func GetConnectionString() string {
-	dockerEnv := os.Getenv(DockerConnectionString)
-	if dockerEnv != "" {
-		return strings.Replace(dockerEnv, "tcp://", "", 1)
-	}
+	sessions := sessionHandler{connection: connection, auth: auth}
+	handler := http.NewServeMux()
+	handler.Handle("/v1/auth/", sessions.routes())
+	handler.HandleFunc("/v1/users/availability", sessions.availability)
+	handler.Handle("/v1/stats/", statsHandler{stats: drinkStats{connection: connection}}.routes())
+	handler.Handle("/v1/invites/", inviteHandler{connection: connection, publicURL: publicURL}.routes())
+	handler.Handle("/", auth.Guard(api.Handler(), "/v1/users/"))
 
-	normalEnv := os.Getenv(DirectConnectionString)
-	if normalEnv != "" {
-		return normalEnv
-	}
+	return handler
+}
 
-	return FallbackConnectionString
+//Sockets is the socket.io handler with all rooms and running games
+type Sockets struct {
+	server    *socketio.Server
+	registry  *roomRegistry
+	turns     *turnTable
+	booth     *votingBooth
+	moderator *moderator
+	away      *awayPlayers
+	connected int64
 }
 
-//BootstrapAPI blub
-func BootstrapAPI(config *bongo.Config) http.Handler {
-	api := api2go.NewAPI("v1")
-	connection, err := bongo.Connect(config)
-	defer connection.Session.Close()
+//Connections returns the number of connected sockets
+func (s *Sockets) Connections() int {
+	return int(atomic.LoadInt64(&s.connected))
+}
 
-	if err != nil {
-		log.Fatal(err)
+//RoomConnections returns the number of sockets in every game room
+func (s *Sockets) RoomConnections() map[string]int {
+	return s.registry.counts()
+}
+
+//RunningGames returns the number of games whose turns are being played
+func (s *Sockets) RunningGames() int {
+	return s.turns.running()
+}
+
+//Votes returns the number of votes of the last minute and since the start
+func (s *Sockets) Votes() (lastMinute, total int) {
+	return s.booth.votes(time.Now())
+}
+
+//ServeHTTP satisfies the http.Handler interface
+func (s *Sockets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.server.ServeHTTP(w, r)
+}
+
+//Moderation returns the handler of the moderation api of the hosts
+func (s *Sockets) Moderation() http.Handler {
+	return s.moderator.routes()
+}
+
+//Shutdown tells every game room that the server goes down and stores
+//the turns of all running games, so they can be continued after a restart
+func (s *Sockets) Shutdown() {
+	for _, room := range s.registry.rooms() {
+		s.server.BroadcastTo(room, "server-shutdown", "The server is restarting, please reconnect in a moment")
 	}
 
-	api.AddResource(User{}, UserSource{connection: connection})
+	s.away.stop()
+	s.turns.suspend()
+}
+
+//Resume continues the turns and open votes of all running games,
+//clients that join again continue the same round
+func (s *Sockets) Resume() error {
+	if err := s.turns.resume(); err != nil {
+		return err
+	}
 
-	return api.Handler()
+	return s.booth.resume()
 }
 
-//BootstrapWebsocket configures the api and returns the corresponding handler
-func BootstrapWebsocket(config *bongo.Config) http.Handler {
+//BootstrapWebsocket configures the api and returns the corresponding handler,
+//only sockets with a valid session token can connect. Room messages only
+//reach the sockets of this process if adaptor is nil. Chat messages
+//have every word of chatWords starred out. Players that lose the
+//connection keep their seat for gracePeriod.
+func BootstrapWebsocket(connection *bongo.Connection, auth *Authenticator, adaptor socketio.BroadcastAdaptor, chatWords []string, gracePeriod time.Duration) (*Sockets, error) {
 	server, err := socketio.NewServer(nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	if adaptor != nil {
+		server.SetAdaptor(adaptor)
+	}
+
+	server.SetAllowRequest(func(r *http.Request) error {
+		_, err := auth.Authenticate(r)
+		return err
+	})
+
+	registry := newRoomRegistry()
+	turns := newTurnTable(server, connection, registry)
+	chat := newChatRoom(server, connection, registry, chatWords)
+	away := newAwayPlayers(gracePeriod)
+	rooms := &gameRooms{
+		server:     server,
+		connection: connection,
+		registry:   registry,
+		auth:       auth,
+		turns:      turns,
+		chat:       chat,
+		away:       away,
+	}
+	booth := newVotingBooth(server, connection, registry)
+	moderator := newModerator(server, connection, registry, turns, auth, away)
+	sockets := &Sockets{server: server, registry: registry, turns: turns, booth: booth, moderator: moderator, away: away}
+
 	server.On("connection", func(so socketio.Socket) {
 		log.Println("on connection")
-		so.Join("chat")
+		atomic.AddInt64(&sockets.connected, 1)
+		rooms.reconnect(so)
+		so.On("join", func(code string) {
+			rooms.join(so, code)
+		})
+		so.On("vote-open", func(request voteRequest) {
+			booth.open(so, request)
+		})
+		so.On("vote", func(ballot ballot) {
+			booth.vote(so, ballot)
+		})
+		so.On("start-game", func() {
+			turns.start(so)
+		})
+		so.On("turn-done", func() {
+			turns.done(so)
+		})
+		so.On("turn-skip", func() {
+			moderator.fromSocket(so, ModerationSkip, moderationRequest{})
+		})
+		so.On("kick", func(request moderationRequest) {
+			moderator.fromSocket(so, ModerationKick, request)
+		})
+		so.On("mute", func(request moderationRequest) {
+			moderator.fromSocket(so, ModerationMute, request)
+		})
+		so.On("unmute", func(request moderationRequest) {
+			moderator.fromSocket(so, ModerationUnmute, request)
+		})
+		so.On("pause-game", func() {
+			moderator.fromSocket(so, ModerationPause, moderationRequest{})
+		})
+		so.On("resume-game", func() {
+			moderator.fromSocket(so, ModerationResume, moderationRequest{})
+		})
+		so.On("transfer-host", func(request moderationRequest) {
+			moderator.fromSocket(so, ModerationTransfer, request)
+		})
+		so.On("chat message", func(text string) {
+			chat.send(so, text)
+		})
+		so.On("leave", func() {
+			rooms.leave(so, true)
+		})
 		so.On("disconnection", func() {
 			log.Println("on disconnect")
-			so.BroadcastTo("chat", "chat message", "fick dich")
+			atomic.AddInt64(&sockets.connected, -1)
+			rooms.leave(so, false)
+			chat.limiter.forget(so.Id())
 		})
 	})
 	server.On("error", func(so socketio.Socket, err error) {
 		log.Println("error:", err)
 	})
 
-	return server
+	return sockets, nil
 }
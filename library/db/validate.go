@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+//fieldError is a violation of a validation rule by a single attribute
+//or relationship
+type fieldError struct {
+	Field        string
+	Msg          string
+	Relationship bool
+}
+
+//Error satisfies the error interface
+func (e fieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+//Pointer returns the json pointer to the field in a request document
+func (e fieldError) Pointer() string {
+	if e.Relationship {
+		return "/data/relationships/" + e.Field
+	}
+
+	return "/data/attributes/" + e.Field
+}
+
+//stringRule checks a string value and describes why it is not accepted,
+//an empty message means the value is fine
+type stringRule func(value string) string
+
+//required refuses empty values
+func required(value string) string {
+	if value == "" {
+		return "Must not be empty"
+	}
+
+	return ""
+}
+
+//maxLength refuses values longer than max characters
+func maxLength(max int) stringRule {
+	return func(value string) string {
+		if utf8.RuneCountInString(value) > max {
+			return fmt.Sprintf("Must not be longer than %d characters", max)
+		}
+
+		return ""
+	}
+}
+
+//printable refuses control characters and invalid utf8
+func printable(value string) string {
+	if !utf8.ValidString(value) {
+		return "Must be valid utf-8"
+	}
+
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return "Must not contain control characters"
+		}
+	}
+
+	return ""
+}
+
+//between refuses numbers outside of min and max
+func between(name string, value, min, max int) error {
+	if value < min || value > max {
+		return fieldError{Field: name, Msg: fmt.Sprintf("Must be between %d and %d", min, max)}
+	}
+
+	return nil
+}
+
+//fieldCheck holds the value of one attribute and its rules
+type fieldCheck struct {
+	Field string
+	Value string
+	Rules []stringRule
+}
+
+//field declares the rules for an attribute
+func field(name, value string, rules ...stringRule) fieldCheck {
+	return fieldCheck{Field: name, Value: value, Rules: rules}
+}
+
+//validate returns the first violation of each field, so a client gets
+//one error object per broken attribute
+func validate(fields ...fieldCheck) []error {
+	var errs []error
+	for _, f := range fields {
+		for _, rule := range f.Rules {
+			if msg := rule(f.Value); msg != "" {
+				errs = append(errs, fieldError{Field: f.Field, Msg: msg})
+				break
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/manyminds/api2go"
+	"github.com/maxwellhealth/bongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	It("Should accept a valid user", func() {
+		user := User{Username: "Unittest", Nickname: "Ünit Test"}
+		Expect(user.Validate(nil)).To(BeEmpty())
+	})
+
+	It("Should refuse empty, long and unprintable usernames", func() {
+		for _, username := range []string{"", strings.Repeat("a", 500), "unit\x00test", "unit\ntest", "\xff"} {
+			errs := User{Username: username}.Validate(nil)
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].(fieldError).Field).To(Equal("username"))
+		}
+	})
+
+	It("Should report every broken field once", func() {
+		errs := User{Username: "", Nickname: strings.Repeat("\t", 100)}.Validate(nil)
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].(fieldError).Pointer()).To(Equal("/data/attributes/username"))
+		Expect(errs[1].(fieldError).Pointer()).To(Equal("/data/attributes/nickname"))
+	})
+
+	It("Should turn violations into json api errors with pointers", func() {
+		errs := User{Username: ""}.Validate(nil)
+		err := saveError(&bongo.ValidationError{Errors: errs})
+		Expect(statusOf(err)).To(Equal(400))
+
+		httpErr := err.(api2go.HTTPError)
+		Expect(httpErr.Errors).To(HaveLen(1))
+		Expect(httpErr.Errors[0].Source.Pointer).To(Equal("/data/attributes/username"))
+		Expect(httpErr.Errors[0].Detail).To(Equal("Must not be empty"))
+	})
+})
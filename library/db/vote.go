@@ -0,0 +1,131 @@
+package db
+
+import (
+	"hash/crc32"
+	"sort"
+	"time"
+
+	"github.com/manyminds/soyfr/library/game"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//Vote is the ballot of one player in a voting round
+type Vote struct {
+	ID      bson.ObjectId `bson:"_id"`
+	GameID  bson.ObjectId
+	RoundID bson.ObjectId
+	VoterID string
+	Choice  string
+	Created time.Time
+	exists  bool
+}
+
+//SetIsNew satisfies the document base
+func (v *Vote) SetIsNew(isNew bool) {
+	v.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (v Vote) IsNew() bool {
+	return !v.exists
+}
+
+//GetId Satisfy the document interface
+func (v Vote) GetId() bson.ObjectId {
+	return v.ID
+}
+
+//SetId satisfy the document interface
+func (v *Vote) SetId(id bson.ObjectId) {
+	v.ID = id
+}
+
+//VoteResult is the outcome of a closed voting round,
+//its id is the id of the round
+type VoteResult struct {
+	ID       bson.ObjectId `bson:"_id"`
+	GameID   bson.ObjectId
+	Question string
+	Options  []string
+	Tally    map[string]int
+	Winner   string
+	Tied     []string
+	Reason   string
+	Sips     int
+	Capped   bool
+	Effects  []game.Effect `bson:",omitempty"`
+	Opened   time.Time
+	Closed   time.Time
+	exists   bool
+}
+
+//SetIsNew satisfies the document base
+func (r *VoteResult) SetIsNew(isNew bool) {
+	r.exists = !isNew
+}
+
+//IsNew satisfies the document base
+func (r VoteResult) IsNew() bool {
+	return !r.exists
+}
+
+//GetId Satisfy the document interface
+func (r VoteResult) GetId() bson.ObjectId {
+	return r.ID
+}
+
+//SetId satisfy the document interface
+func (r *VoteResult) SetId(id bson.ObjectId) {
+	r.ID = id
+}
+
+//tally counts the votes for each option, votes for unknown options are ignored
+func tally(options []string, votes []Vote) map[string]int {
+	counts := make(map[string]int, len(options))
+	for _, option := range options {
+		counts[option] = 0
+	}
+
+	for _, vote := range votes {
+		if _, ok := counts[vote.Choice]; ok {
+			counts[vote.Choice]++
+		}
+	}
+
+	return counts
+}
+
+//pickWinner returns the option with the most votes and all options that
+//shared the highest count. Ties are broken with a checksum of the round id,
+//so replaying the same votes always gives the same winner. Without any
+//vote there is no winner.
+func pickWinner(roundID bson.ObjectId, counts map[string]int) (string, []string) {
+	if len(counts) == 0 {
+		return "", nil
+	}
+
+	highest := -1
+	var tied []string
+	for option, count := range counts {
+		switch {
+		case count > highest:
+			highest = count
+			tied = []string{option}
+		case count == highest:
+			tied = append(tied, option)
+		}
+	}
+
+	if highest == 0 {
+		return "", nil
+	}
+
+	sort.Strings(tied)
+	if len(tied) == 1 {
+		return tied[0], nil
+	}
+
+	index := crc32.ChecksumIEEE([]byte(roundID)) % uint32(len(tied))
+
+	return tied[index], tied
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Vote", func() {
+	options := []string{"anna", "ben", "carl"}
+
+	Context("tally", func() {
+		It("Should count votes per option", func() {
+			votes := []Vote{{Choice: "anna"}, {Choice: "ben"}, {Choice: "anna"}}
+			Expect(tally(options, votes)).To(Equal(map[string]int{"anna": 2, "ben": 1, "carl": 0}))
+		})
+
+		It("Should ignore votes for unknown options", func() {
+			votes := []Vote{{Choice: "dave"}}
+			Expect(tally(options, votes)).To(Equal(map[string]int{"anna": 0, "ben": 0, "carl": 0}))
+		})
+	})
+
+	Context("winner", func() {
+		It("Should pick the option with most votes", func() {
+			winner, tied := pickWinner(bson.NewObjectId(), map[string]int{"anna": 2, "ben": 1})
+			Expect(winner).To(Equal("anna"))
+			Expect(tied).To(BeNil())
+		})
+
+		It("Should break ties the same way for the same round", func() {
+			roundID := bson.NewObjectId()
+			counts := map[string]int{"anna": 2, "ben": 2, "carl": 1}
+
+			winner, tied := pickWinner(roundID, counts)
+			Expect(tied).To(Equal([]string{"anna", "ben"}))
+			Expect(tied).To(ContainElement(winner))
+
+			for i := 0; i < 10; i++ {
+				again, _ := pickWinner(roundID, counts)
+				Expect(again).To(Equal(winner))
+			}
+		})
+
+		It("Should not pick anyone without options", func() {
+			winner, _ := pickWinner(bson.NewObjectId(), map[string]int{})
+			Expect(winner).To(Equal(""))
+		})
+
+		It("Should not pick anyone without votes", func() {
+			winner, tied := pickWinner(bson.NewObjectId(), map[string]int{"anna": 0, "ben": 0})
+			Expect(winner).To(Equal(""))
+			Expect(tied).To(BeEmpty())
+		})
+	})
+
+	Context("rate", func() {
+		It("Should only count the votes of the last minute", func() {
+			booth := &votingBooth{}
+			start := time.Date(2015, 8, 1, 20, 0, 0, 0, time.UTC)
+			booth.count(start)
+			booth.count(start.Add(30 * time.Second))
+			booth.count(start.Add(45 * time.Second))
+
+			lastMinute, total := booth.votes(start.Add(80 * time.Second))
+			Expect(lastMinute).To(Equal(2))
+			Expect(total).To(Equal(3))
+		})
+	})
+
+	Context("house rules", func() {
+		It("Should hand the voters to the rules in the order they voted", func() {
+			start := time.Date(2015, 8, 1, 20, 0, 0, 0, time.UTC)
+			ballots := []Vote{
+				{VoterID: "carl", Created: start.Add(2 * time.Second)},
+				{VoterID: "anna", Created: start},
+				{VoterID: "ben", Created: start.Add(time.Second)},
+			}
+
+			Expect(voters(ballots)).To(Equal(options))
+		})
+	})
+})
@@ -0,0 +1,418 @@
+package db
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/googollee/go-socket.io"
+	"github.com/manyminds/soyfr/library/game"
+	"github.com/maxwellhealth/bongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//defaultVoteTimeout is used when a round is opened without timeout
+	defaultVoteTimeout = 30 * time.Second
+	//maxVoteTimeout is the longest a round may stay open
+	maxVoteTimeout = 5 * time.Minute
+)
+
+//voteRequest is sent by a client to open a voting round in its game,
+//without options the players in the room are the options and the
+//player that wins the vote drinks the sips
+type voteRequest struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	Timeout  int      `json:"timeout"`
+	Sips     int      `json:"sips"`
+}
+
+//ballot is sent by a client to vote in the open round
+type ballot struct {
+	Round  string `json:"round"`
+	Choice string `json:"choice"`
+}
+
+//voteRound is the open voting round of a game room
+type voteRound struct {
+	ID       bson.ObjectId `json:"id"`
+	Question string        `json:"question"`
+	Options  []string      `json:"options"`
+	Deadline time.Time     `json:"deadline"`
+	Sips     int           `json:"sips"`
+	room     string
+	opened   time.Time
+	votes    map[string]Vote
+	timer    *time.Timer
+}
+
+//ballots returns all votes of the round
+func (r *voteRound) ballots() []Vote {
+	votes := make([]Vote, 0, len(r.votes))
+	for _, vote := range r.votes {
+		votes = append(votes, vote)
+	}
+
+	return votes
+}
+
+//hasOption checks if choice can be voted for
+func (r *voteRound) hasOption(choice string) bool {
+	for _, option := range r.Options {
+		if option == choice {
+			return true
+		}
+	}
+
+	return false
+}
+
+//storedRound is an open round as it is kept with its game,
+//so it can be continued after a restart
+type storedRound struct {
+	ID       bson.ObjectId
+	Question string
+	Options  []string
+	Deadline time.Time
+	Sips     int
+	Opened   time.Time
+	Votes    []Vote
+}
+
+//stored must be called with the lock of the booth held
+func (r *voteRound) stored() storedRound {
+	return storedRound{
+		ID:       r.ID,
+		Question: r.Question,
+		Options:  r.Options,
+		Deadline: r.Deadline,
+		Sips:     r.Sips,
+		Opened:   r.opened,
+		Votes:    r.ballots(),
+	}
+}
+
+//votingBooth runs at most one voting round per game room
+type votingBooth struct {
+	sync.Mutex
+	server     broadcaster
+	connection *bongo.Connection
+	registry   *roomRegistry
+	ledger     drinkLedger
+	guard      safeguard
+	rounds     map[string]*voteRound
+	cast       []time.Time
+	total      int
+}
+
+func newVotingBooth(server broadcaster, connection *bongo.Connection, registry *roomRegistry) *votingBooth {
+	return &votingBooth{
+		server:     server,
+		connection: connection,
+		registry:   registry,
+		ledger:     drinkLedger{connection: connection},
+		guard:      newSafeguard(connection),
+		rounds:     make(map[string]*voteRound),
+	}
+}
+
+//open starts a new round in the game room of the socket
+func (b *votingBooth) open(so socketio.Socket, request voteRequest) {
+	room := b.registry.roomOf(so.Id())
+	if room == "" {
+		so.Emit("vote-failed", "Join a game before voting")
+		return
+	}
+
+	options := request.Options
+	if len(options) == 0 {
+		for userID := range b.registry.users(room) {
+			options = append(options, userID)
+		}
+
+		sort.Strings(options)
+	}
+
+	if request.Sips < 0 || request.Sips > maxSips {
+		so.Emit("vote-failed", "Invalid number of sips")
+		return
+	}
+
+	if len(options) < 2 {
+		so.Emit("vote-failed", "A vote needs at least two options")
+		return
+	}
+
+	timeout := time.Duration(request.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultVoteTimeout
+	}
+
+	if timeout > maxVoteTimeout {
+		timeout = maxVoteTimeout
+	}
+
+	b.Lock()
+	if _, running := b.rounds[room]; running {
+		b.Unlock()
+		so.Emit("vote-failed", "There is already an open vote")
+		return
+	}
+
+	now := time.Now()
+	round := &voteRound{
+		ID:       bson.NewObjectId(),
+		Question: request.Question,
+		Options:  options,
+		Deadline: now.Add(timeout),
+		Sips:     request.Sips,
+		room:     room,
+		opened:   now,
+		votes:    make(map[string]Vote),
+	}
+
+	b.schedule(round, timeout)
+	b.rounds[room] = round
+	b.persist(round)
+	b.Unlock()
+
+	b.server.BroadcastTo(room, "vote-opened", round)
+}
+
+//vote records the ballot of a player and closes the round
+//once every player in the room has voted
+func (b *votingBooth) vote(so socketio.Socket, ballot ballot) {
+	member, room, _ := b.registry.memberOf(so.Id())
+
+	b.Lock()
+	round, ok := b.rounds[room]
+	if !ok || round.ID.Hex() != ballot.Round {
+		b.Unlock()
+		so.Emit("vote-failed", "This vote is already closed")
+		return
+	}
+
+	if !round.hasOption(ballot.Choice) {
+		b.Unlock()
+		so.Emit("vote-failed", "Unknown option")
+		return
+	}
+
+	vote := Vote{
+		ID:      bson.NewObjectId(),
+		GameID:  roomGameID(room),
+		RoundID: round.ID,
+		VoterID: member.UserID,
+		Choice:  ballot.Choice,
+		Created: time.Now(),
+	}
+
+	//players may change their mind until the round is closed,
+	//a player with more than one socket only has one vote
+	if previous, ok := round.votes[member.UserID]; ok {
+		vote.ID = previous.ID
+	}
+
+	round.votes[member.UserID] = vote
+	b.count(vote.Created)
+	players := b.registry.users(room)
+	complete := true
+	for userID := range players {
+		if _, voted := round.votes[userID]; !voted {
+			complete = false
+			break
+		}
+	}
+
+	progress := map[string]interface{}{"round": round.ID, "votes": len(round.votes), "players": len(players)}
+	b.persist(round)
+	b.Unlock()
+
+	if err := b.connection.Collection("vote").Save(&vote); err != nil {
+		log.Printf("could not store vote of %s: %s\n", member.UserID, err)
+	}
+
+	b.server.BroadcastTo(room, "vote-cast", progress)
+
+	if complete {
+		b.close(room, round.ID, "complete")
+	}
+}
+
+//schedule closes the round once timeout is over
+func (b *votingBooth) schedule(round *voteRound, timeout time.Duration) {
+	round.timer = time.AfterFunc(timeout, func() {
+		b.close(round.room, round.ID, "timeout")
+	})
+}
+
+//persist must be called with the lock held, it stores the open round
+//with its game so votes are not lost on a restart
+func (b *votingBooth) persist(round *voteRound) {
+	update := bson.M{"$set": bson.M{"openvote": round.stored()}}
+	if err := b.connection.Collection("game").Collection().UpdateId(roomGameID(round.room), update); err != nil {
+		log.Printf("could not store vote %s: %s\n", round.ID.Hex(), err)
+	}
+}
+
+//resume opens the stored rounds of all unfinished games again, rounds whose
+//deadline passed while the server was down are closed right away
+func (b *votingBooth) resume() error {
+	stored := Game{}
+	query := bson.M{"state": bson.M{"$ne": GameStateFinished}, "openvote": bson.M{"$exists": true}}
+	resultSet := b.connection.Collection("game").Find(query)
+	for resultSet.Next(&stored) {
+		open := stored.OpenVote
+		round := &voteRound{
+			ID:       open.ID,
+			Question: open.Question,
+			Options:  open.Options,
+			Deadline: open.Deadline,
+			Sips:     open.Sips,
+			room:     roomName(stored),
+			opened:   open.Opened,
+			votes:    make(map[string]Vote),
+		}
+
+		for _, vote := range open.Votes {
+			round.votes[vote.VoterID] = vote
+		}
+
+		b.Lock()
+		b.rounds[round.room] = round
+		b.schedule(round, round.Deadline.Sub(time.Now()))
+		b.Unlock()
+
+		stored = Game{}
+	}
+
+	return resultSet.Error
+}
+
+//count must be called with the lock held, it remembers the votes of the last minute
+func (b *votingBooth) count(now time.Time) {
+	b.total++
+	b.cast = append(b.cast, now)
+	b.forget(now)
+}
+
+//forget must be called with the lock held, it drops votes older than a minute
+func (b *votingBooth) forget(now time.Time) {
+	recent := 0
+	for recent < len(b.cast) && now.Sub(b.cast[recent]) > time.Minute {
+		recent++
+	}
+
+	b.cast = b.cast[recent:]
+}
+
+//votes returns the number of votes of the last minute and since the start
+func (b *votingBooth) votes(now time.Time) (lastMinute, total int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.forget(now)
+	return len(b.cast), b.total
+}
+
+//close tallies the round, broadcasts the result and stores it
+func (b *votingBooth) close(room string, roundID bson.ObjectId, reason string) {
+	b.Lock()
+	round, ok := b.rounds[room]
+	if !ok || round.ID != roundID {
+		b.Unlock()
+		return
+	}
+
+	delete(b.rounds, room)
+	round.timer.Stop()
+	b.Unlock()
+
+	update := bson.M{"$unset": bson.M{"openvote": ""}}
+	if err := b.connection.Collection("game").Collection().UpdateId(roomGameID(room), update); err != nil {
+		log.Printf("could not remove vote %s: %s\n", round.ID.Hex(), err)
+	}
+
+	ballots := round.ballots()
+	counts := tally(round.Options, ballots)
+	winner, tied := pickWinner(round.ID, counts)
+	gameID := roomGameID(room)
+	rules := b.houseRules(gameID)
+	closed := time.Now()
+
+	//the winner never drinks more than the personal limit allows,
+	//a round nobody voted in has no winner and nobody drinks
+	ruled := rules.Sips(round.Sips, closed)
+	if winner == "" {
+		ruled = 0
+	}
+
+	sips := ruled
+	if sips > 0 && bson.IsObjectIdHex(winner) {
+		sips = limitSips(b.guard.limit(winner, gameID), sips)
+	}
+
+	effects := rules.VoteClosed(game.VoteOutcome{Winner: winner, Voters: voters(ballots), Sips: sips}, closed)
+
+	result := VoteResult{
+		ID:       round.ID,
+		GameID:   gameID,
+		Question: round.Question,
+		Options:  round.Options,
+		Tally:    counts,
+		Winner:   winner,
+		Tied:     tied,
+		Reason:   reason,
+		Sips:     sips,
+		Capped:   sips < ruled,
+		Effects:  effects,
+		Opened:   round.opened,
+		Closed:   closed,
+	}
+
+	b.server.BroadcastTo(room, "vote-result", result)
+
+	if err := b.connection.Collection("voteResult").Save(&result); err != nil {
+		log.Printf("could not store result of vote %s: %s\n", round.ID.Hex(), err)
+	}
+
+	if sips > 0 && bson.IsObjectIdHex(winner) {
+		err := b.ledger.record(bson.ObjectIdHex(winner), gameID, DrinkReasonVote, sips)
+		if err != nil {
+			log.Printf("could not record drink of %s: %s\n", winner, err)
+		}
+	}
+
+	b.ledger.effects(b.guard, gameID, effects)
+}
+
+//houseRules returns the rules of the game a round is closed in
+func (b *votingBooth) houseRules(gameID bson.ObjectId) game.Rules {
+	stored := Game{}
+	if err := b.connection.Collection("game").FindById(gameID, &stored); err != nil {
+		log.Printf("could not load the house rules of game %s: %s\n", gameID.Hex(), err)
+		return nil
+	}
+
+	return stored.HouseRules()
+}
+
+//voters returns the voters of the ballots in the order they voted
+func voters(ballots []Vote) []string {
+	sort.Sort(byCreated(ballots))
+	voters := make([]string, 0, len(ballots))
+	for _, ballot := range ballots {
+		voters = append(voters, ballot.VoterID)
+	}
+
+	return voters
+}
+
+//byCreated sorts votes by the time they were cast
+type byCreated []Vote
+
+func (v byCreated) Len() int           { return len(v) }
+func (v byCreated) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byCreated) Less(i, j int) bool { return v[i].Created.Before(v[j].Created) }
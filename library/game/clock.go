@@ -0,0 +1,33 @@
+package game
+
+import "time"
+
+//Timer is a scheduled call that can be cancelled
+type Timer interface {
+	Stop() bool
+}
+
+//Clock tells the time and schedules calls, tests replace it with a fake
+//so turns can time out without waiting
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+//realClock uses the time package
+type realClock struct{}
+
+//Now returns the current time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+//AfterFunc calls f in its own goroutine after d
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+//RealClock returns the clock used outside of tests
+func RealClock() Clock {
+	return realClock{}
+}
@@ -0,0 +1,609 @@
+package game
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+//DefaultTurnTimeout is used for challenges without their own timer
+const DefaultTurnTimeout = 60 * time.Second
+
+var (
+	//ErrNotRunning is returned for actions on a game that is not in a turn
+	ErrNotRunning = errors.New("The game is not running")
+	//ErrAlreadyStarted is returned when a running game is started again
+	ErrAlreadyStarted = errors.New("The game is already running")
+	//ErrNoPlayers is returned when a game is started without players
+	ErrNoPlayers = errors.New("A game needs at least one player")
+	//ErrNotYourTurn is returned when another player tries to end the turn
+	ErrNotYourTurn = errors.New("It is not your turn")
+	//ErrPaused is returned for turn actions while the game is paused
+	ErrPaused = errors.New("The game is paused")
+	//ErrNotPaused is returned when a game that is not paused is continued
+	ErrNotPaused = errors.New("The game is not paused")
+)
+
+//Phase is the part of the game the engine is in
+type Phase string
+
+const (
+	//PhaseWaiting means the engine was not started yet
+	PhaseWaiting Phase = "waiting"
+	//PhaseTurn means a player has to complete a challenge
+	PhaseTurn Phase = "turn"
+	//PhaseFinished means no challenge is left for the players
+	PhaseFinished Phase = "finished"
+)
+
+//Outcome tells how a turn ended
+type Outcome string
+
+const (
+	//OutcomeCompleted is a turn the player finished
+	OutcomeCompleted Outcome = "completed"
+	//OutcomeTimeout is a turn that ran out of time
+	OutcomeTimeout Outcome = "timeout"
+	//OutcomeSkipped is a turn the host skipped or the player left
+	OutcomeSkipped Outcome = "skipped"
+)
+
+//Events sent to the game room
+const (
+	EventTurnStarted  = "turn-started"
+	EventTurnEnded    = "turn-ended"
+	EventGameFinished = "game-finished"
+	EventGamePaused   = "game-paused"
+	EventGameResumed  = "game-resumed"
+)
+
+//Challenge is a card the engine draws, players are only given challenges
+//that fit the number of players
+type Challenge struct {
+	ID         string `json:"id"`
+	Text       string `json:"text"`
+	Category   string `json:"category"`
+	Sips       int    `json:"sips"`
+	MinPlayers int    `json:"minPlayers"`
+	MaxPlayers int    `json:"maxPlayers"`
+	Timer      int    `json:"timer"`
+}
+
+//Fits checks if the challenge can be played with that many players
+func (c Challenge) Fits(players int) bool {
+	if c.MinPlayers > 0 && players < c.MinPlayers {
+		return false
+	}
+
+	return c.MaxPlayers == 0 || players <= c.MaxPlayers
+}
+
+//State is everything a client needs to show the current turn,
+//it is sent with every event and to clients that reconnect. The deadline
+//of a paused turn is moved when the game continues.
+type State struct {
+	GameID    string     `json:"gameId"`
+	Phase     Phase      `json:"phase"`
+	Turn      int        `json:"turn"`
+	Player    string     `json:"player,omitempty"`
+	Challenge *Challenge `json:"challenge,omitempty"`
+	Deadline  time.Time  `json:"deadline"`
+	Players   []string   `json:"players"`
+	Remaining int        `json:"remaining"`
+	Outcome   Outcome    `json:"outcome,omitempty"`
+	Capped    bool       `json:"capped"`
+	Paused    bool       `json:"paused"`
+	Effects   []Effect   `json:"effects,omitempty"`
+}
+
+//Snapshot is everything needed to continue a game with another engine,
+//for example after the server restarted
+type Snapshot struct {
+	State State
+	Drawn []string
+	Next  int
+	Left  time.Duration
+}
+
+//Broadcaster sends an event to everyone in the game
+type Broadcaster interface {
+	Broadcast(event string, state State)
+}
+
+//Allowance returns how many sips a player may still drink,
+//a negative number means there is no limit
+type Allowance func(player string) int
+
+//unlimited is the allowance if none is configured
+func unlimited(player string) int {
+	return -1
+}
+
+//DefaultSubstitute is given to players that reached their limit
+//if the deck has no challenge with few enough sips
+var DefaultSubstitute = Challenge{
+	Text:     "Drink a glass of water, you earned it",
+	Category: "water",
+}
+
+//Persist stores a snapshot, it is called with the engine locked
+//and must not call the engine
+type Persist func(snapshot Snapshot)
+
+//Options configure an engine, all fields are optional
+type Options struct {
+	Clock       Clock
+	TurnTimeout time.Duration
+	Random      *rand.Rand
+	Allowance   Allowance
+	Substitute  *Challenge
+	Persist     Persist
+	Rules       Rules
+}
+
+//Engine runs the turns of a single game. Players take turns in the order
+//they joined, every turn draws a challenge that was not drawn before and
+//ends when the player completes it, the host skips it or time runs out.
+//Players are never given more sips than their allowance. The house rules
+//change the sips of drawn challenges and add effects to every ended turn.
+//Every change is handed to Persist, so the game can be restored.
+type Engine struct {
+	sync.Mutex
+	out         Broadcaster
+	clock       Clock
+	random      *rand.Rand
+	turnTimeout time.Duration
+	allowance   Allowance
+	persist     Persist
+	rules       Rules
+	substitute  Challenge
+	deck        []Challenge
+	drawn       map[string]bool
+	state       State
+	next        int
+	left        time.Duration
+	timer       Timer
+}
+
+//NewEngine returns an engine that waits to be started
+func NewEngine(gameID string, players []string, deck []Challenge, out Broadcaster, options Options) *Engine {
+	if options.Clock == nil {
+		options.Clock = RealClock()
+	}
+
+	if options.TurnTimeout <= 0 {
+		options.TurnTimeout = DefaultTurnTimeout
+	}
+
+	if options.Random == nil {
+		options.Random = rand.New(rand.NewSource(options.Clock.Now().UnixNano()))
+	}
+
+	if options.Allowance == nil {
+		options.Allowance = unlimited
+	}
+
+	if options.Persist == nil {
+		options.Persist = func(Snapshot) {}
+	}
+
+	substitute := DefaultSubstitute
+	if options.Substitute != nil {
+		substitute = *options.Substitute
+	}
+
+	substitute.Sips = 0
+
+	return &Engine{
+		out:         out,
+		clock:       options.Clock,
+		random:      options.Random,
+		turnTimeout: options.TurnTimeout,
+		allowance:   options.Allowance,
+		persist:     options.Persist,
+		rules:       options.Rules,
+		substitute:  substitute,
+		deck:        deck,
+		drawn:       make(map[string]bool),
+		state: State{
+			GameID:  gameID,
+			Phase:   PhaseWaiting,
+			Players: append([]string{}, players...),
+		},
+	}
+}
+
+//Restore returns an engine that continues the game of the snapshot,
+//it has to be resumed to run the timer of the current turn
+func Restore(snapshot Snapshot, deck []Challenge, out Broadcaster, options Options) *Engine {
+	state := snapshot.State
+	e := NewEngine(state.GameID, state.Players, deck, out, options)
+	state.Players = e.state.Players
+	e.state = state
+	e.next = snapshot.Next
+	e.left = snapshot.Left
+	for _, id := range snapshot.Drawn {
+		e.drawn[id] = true
+	}
+
+	return e
+}
+
+//State returns a copy of the current state
+func (e *Engine) State() State {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.snapshot()
+}
+
+//snapshot must be called with the lock held
+func (e *Engine) snapshot() State {
+	state := e.state
+	state.Players = append([]string{}, e.state.Players...)
+	state.Remaining = e.remaining()
+	if e.state.Challenge != nil {
+		challenge := *e.state.Challenge
+		state.Challenge = &challenge
+	}
+
+	return state
+}
+
+//Snapshot returns everything needed to restore the engine
+func (e *Engine) Snapshot() Snapshot {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.save()
+}
+
+//save must be called with the lock held
+func (e *Engine) save() Snapshot {
+	drawn := make([]string, 0, len(e.drawn))
+	for id := range e.drawn {
+		drawn = append(drawn, id)
+	}
+
+	sort.Strings(drawn)
+
+	return Snapshot{State: e.snapshot(), Drawn: drawn, Next: e.next, Left: e.left}
+}
+
+//Start begins the first turn
+func (e *Engine) Start() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseWaiting {
+		return ErrAlreadyStarted
+	}
+
+	if len(e.state.Players) == 0 {
+		return ErrNoPlayers
+	}
+
+	e.nextTurn()
+
+	return nil
+}
+
+//Resume continues the turn of a restored engine, it ends right away
+//if its deadline passed in the meantime. A paused game stays paused.
+func (e *Engine) Resume() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn {
+		return ErrNotRunning
+	}
+
+	if e.timer != nil {
+		return ErrAlreadyStarted
+	}
+
+	if e.state.Paused {
+		return nil
+	}
+
+	remaining := e.state.Deadline.Sub(e.clock.Now())
+	if remaining <= 0 {
+		e.endTurn(OutcomeTimeout)
+		return nil
+	}
+
+	e.schedule(remaining)
+
+	return nil
+}
+
+//Complete ends the turn of player
+func (e *Engine) Complete(player string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn {
+		return ErrNotRunning
+	}
+
+	if e.state.Paused {
+		return ErrPaused
+	}
+
+	if e.state.Player != player {
+		return ErrNotYourTurn
+	}
+
+	e.endTurn(OutcomeCompleted)
+
+	return nil
+}
+
+//Skip ends the current turn without completing it
+func (e *Engine) Skip() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn {
+		return ErrNotRunning
+	}
+
+	if e.state.Paused {
+		return ErrPaused
+	}
+
+	e.endTurn(OutcomeSkipped)
+
+	return nil
+}
+
+//Pause stops the clock of the current turn, the player keeps
+//the time that was left
+func (e *Engine) Pause() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn {
+		return ErrNotRunning
+	}
+
+	if e.state.Paused {
+		return nil
+	}
+
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+
+	e.left = e.state.Deadline.Sub(e.clock.Now())
+	e.state.Paused = true
+	e.out.Broadcast(EventGamePaused, e.snapshot())
+	e.persist(e.save())
+
+	return nil
+}
+
+//Continue starts the clock of a paused turn again
+func (e *Engine) Continue() error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn {
+		return ErrNotRunning
+	}
+
+	if !e.state.Paused {
+		return ErrNotPaused
+	}
+
+	e.state.Paused = false
+	e.state.Deadline = e.clock.Now().Add(e.left)
+	e.schedule(e.left)
+	e.left = 0
+	e.out.Broadcast(EventGameResumed, e.snapshot())
+	e.persist(e.save())
+
+	return nil
+}
+
+//AddPlayer lets a player take part from the next round on
+func (e *Engine) AddPlayer(player string) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.indexOf(player) >= 0 {
+		return
+	}
+
+	e.state.Players = append(e.state.Players, player)
+	e.persist(e.save())
+}
+
+//RemovePlayer takes a player out of the game, if it was the player's
+//turn the turn is skipped
+func (e *Engine) RemovePlayer(player string) {
+	e.Lock()
+	defer e.Unlock()
+
+	index := e.indexOf(player)
+	if index < 0 {
+		return
+	}
+
+	e.state.Players = append(e.state.Players[:index], e.state.Players[index+1:]...)
+	if index < e.next {
+		e.next--
+	}
+
+	if e.state.Phase == PhaseTurn && e.state.Player == player {
+		e.endTurn(OutcomeSkipped)
+		return
+	}
+
+	e.persist(e.save())
+}
+
+//Stop cancels the running turn timer and returns the snapshot the engine
+//was stopped in, the engine can not be used afterwards
+func (e *Engine) Stop() Snapshot {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+
+	snapshot := e.save()
+	e.state.Phase = PhaseFinished
+
+	return snapshot
+}
+
+//indexOf must be called with the lock held
+func (e *Engine) indexOf(player string) int {
+	for i, p := range e.state.Players {
+		if p == player {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//remaining must be called with the lock held, it counts the challenges
+//that can still be drawn for the current players
+func (e *Engine) remaining() int {
+	count := 0
+	for _, challenge := range e.deck {
+		if !e.drawn[challenge.ID] && challenge.Fits(len(e.state.Players)) {
+			count++
+		}
+	}
+
+	return count
+}
+
+//draw must be called with the lock held, it picks a random challenge
+//that was not drawn yet and applies the house rules to its sips. A player
+//that may only drink allowed sips gets a challenge within the limit or
+//the substitute if there is none, capped is true in that case. Unlimited
+//players have a negative allowance.
+func (e *Engine) draw(allowed int) (challenge *Challenge, capped bool) {
+	now := e.clock.Now()
+	var candidates, within []Challenge
+	for _, challenge := range e.deck {
+		if e.drawn[challenge.ID] || !challenge.Fits(len(e.state.Players)) {
+			continue
+		}
+
+		challenge.Sips = e.rules.Sips(challenge.Sips, now)
+		candidates = append(candidates, challenge)
+		if allowed < 0 || challenge.Sips <= allowed {
+			within = append(within, challenge)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	if len(within) == 0 {
+		substitute := e.substitute
+		return &substitute, true
+	}
+
+	picked := within[e.random.Intn(len(within))]
+	e.drawn[picked.ID] = true
+
+	return &picked, allowed >= 0 && len(within) < len(candidates)
+}
+
+//endTurn must be called with the lock held
+func (e *Engine) endTurn(outcome Outcome) {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+
+	e.state.Outcome = outcome
+	e.state.Effects = e.rules.TurnEnded(e.snapshot(), e.clock.Now())
+	e.out.Broadcast(EventTurnEnded, e.snapshot())
+	e.state.Outcome = ""
+	e.state.Effects = nil
+
+	e.nextTurn()
+}
+
+//nextTurn must be called with the lock held, the game finishes
+//if there are no players or challenges left
+func (e *Engine) nextTurn() {
+	if e.next >= len(e.state.Players) {
+		e.next = 0
+	}
+
+	var challenge *Challenge
+	var capped bool
+	if len(e.state.Players) > 0 {
+		challenge, capped = e.draw(e.allowance(e.state.Players[e.next]))
+	}
+
+	if challenge == nil {
+		e.state.Phase = PhaseFinished
+		e.state.Player = ""
+		e.state.Challenge = nil
+		e.state.Capped = false
+		e.state.Paused = false
+		e.out.Broadcast(EventGameFinished, e.snapshot())
+		e.persist(e.save())
+		return
+	}
+
+	timeout := e.turnTimeout
+	if challenge.Timer > 0 {
+		timeout = time.Duration(challenge.Timer) * time.Second
+	}
+
+	e.state.Turn++
+	e.state.Phase = PhaseTurn
+	e.state.Player = e.state.Players[e.next]
+	e.state.Challenge = challenge
+	e.state.Capped = capped
+	e.state.Deadline = e.clock.Now().Add(timeout)
+	e.next++
+
+	//a player that leaves a paused game hands the turn to the next
+	//player, whose clock only starts once the game continues
+	if e.state.Paused {
+		e.left = timeout
+	} else {
+		e.schedule(timeout)
+	}
+
+	e.out.Broadcast(EventTurnStarted, e.snapshot())
+	e.persist(e.save())
+}
+
+//schedule must be called with the lock held, it ends the current
+//turn after timeout
+func (e *Engine) schedule(timeout time.Duration) {
+	turn := e.state.Turn
+	e.timer = e.clock.AfterFunc(timeout, func() {
+		e.expire(turn)
+	})
+}
+
+//expire ends the turn if it is still running when its timer fires
+func (e *Engine) expire(turn int) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.state.Phase != PhaseTurn || e.state.Turn != turn {
+		return
+	}
+
+	e.endTurn(OutcomeTimeout)
+}
@@ -0,0 +1,415 @@
+package game
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+//fakeClock only moves when a test advances it
+type fakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	wasRunning := !t.stopped
+	t.stopped = true
+	return wasRunning
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.Lock()
+	defer c.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+//Advance moves the clock and runs all timers that are due in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	end := c.now.Add(d)
+	c.Unlock()
+
+	for {
+		c.Lock()
+		var due *fakeTimer
+		for _, timer := range c.timers {
+			if timer.stopped || timer.at.After(end) {
+				continue
+			}
+
+			if due == nil || timer.at.Before(due.at) {
+				due = timer
+			}
+		}
+
+		if due == nil {
+			c.now = end
+			c.Unlock()
+			return
+		}
+
+		due.stopped = true
+		c.now = due.at
+		c.Unlock()
+
+		due.f()
+	}
+}
+
+//recorder keeps all broadcast events
+type recorder struct {
+	sync.Mutex
+	events []string
+	states []State
+}
+
+func (r *recorder) Broadcast(event string, state State) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.events = append(r.events, event)
+	r.states = append(r.states, state)
+}
+
+func (r *recorder) last() (string, State) {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.events[len(r.events)-1], r.states[len(r.states)-1]
+}
+
+var _ = Describe("Engine", func() {
+	var (
+		clock  *fakeClock
+		out    *recorder
+		engine *Engine
+		deck   []Challenge
+	)
+
+	newEngine := func(players ...string) *Engine {
+		return NewEngine("game", players, deck, out, Options{
+			Clock:       clock,
+			TurnTimeout: time.Minute,
+			Random:      rand.New(rand.NewSource(42)),
+		})
+	}
+
+	BeforeEach(func() {
+		clock = &fakeClock{now: time.Date(2015, 8, 1, 20, 0, 0, 0, time.UTC)}
+		out = &recorder{}
+		deck = []Challenge{
+			{ID: "a", Text: "Drink", Sips: 1},
+			{ID: "b", Text: "Drink twice", Sips: 2},
+			{ID: "c", Text: "Sing", Sips: 3, Timer: 10},
+		}
+		engine = newEngine("anna", "ben")
+	})
+
+	It("Should wait until it is started", func() {
+		Expect(engine.State().Phase).To(Equal(PhaseWaiting))
+		Expect(out.events).To(BeEmpty())
+		Expect(newEngine().Start()).To(Equal(ErrNoPlayers))
+	})
+
+	It("Should give the first turn to the first player", func() {
+		Expect(engine.Start()).To(Succeed())
+		event, state := out.last()
+		Expect(event).To(Equal(EventTurnStarted))
+		Expect(state.Turn).To(Equal(1))
+		Expect(state.Player).To(Equal("anna"))
+		Expect(state.Challenge).ToNot(BeNil())
+		Expect(state.Remaining).To(Equal(2))
+		Expect(engine.Start()).To(Equal(ErrAlreadyStarted))
+	})
+
+	It("Should only let the current player complete the turn", func() {
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.Complete("ben")).To(Equal(ErrNotYourTurn))
+		Expect(engine.Complete("anna")).To(Succeed())
+
+		Expect(out.events).To(Equal([]string{EventTurnStarted, EventTurnEnded, EventTurnStarted}))
+		Expect(out.states[1].Outcome).To(Equal(OutcomeCompleted))
+		Expect(engine.State().Player).To(Equal("ben"))
+	})
+
+	It("Should draw every challenge once and finish", func() {
+		Expect(engine.Start()).To(Succeed())
+		drawn := map[string]bool{engine.State().Challenge.ID: true}
+		Expect(engine.Skip()).To(Succeed())
+		drawn[engine.State().Challenge.ID] = true
+		Expect(engine.Skip()).To(Succeed())
+		drawn[engine.State().Challenge.ID] = true
+		Expect(drawn).To(HaveLen(3))
+
+		Expect(engine.Skip()).To(Succeed())
+		event, state := out.last()
+		Expect(event).To(Equal(EventGameFinished))
+		Expect(state.Phase).To(Equal(PhaseFinished))
+		Expect(engine.Skip()).To(Equal(ErrNotRunning))
+	})
+
+	It("Should only draw challenges that fit the players", func() {
+		deck = []Challenge{{ID: "big", MinPlayers: 5}, {ID: "small", MaxPlayers: 2}}
+		engine = newEngine("anna", "ben")
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.State().Challenge.ID).To(Equal("small"))
+		Expect(engine.Skip()).To(Succeed())
+		Expect(engine.State().Phase).To(Equal(PhaseFinished))
+	})
+
+	It("Should end turns that run out of time", func() {
+		Expect(engine.Start()).To(Succeed())
+		state := engine.State()
+		timeout := state.Deadline.Sub(clock.Now())
+
+		clock.Advance(timeout - time.Second)
+		Expect(engine.State().Turn).To(Equal(1))
+
+		clock.Advance(time.Second)
+		Expect(out.events[1]).To(Equal(EventTurnEnded))
+		Expect(out.states[1].Outcome).To(Equal(OutcomeTimeout))
+		Expect(engine.State().Turn).To(Equal(2))
+		Expect(engine.State().Player).To(Equal("ben"))
+	})
+
+	It("Should use the timer of the challenge", func() {
+		deck = []Challenge{{ID: "c", Timer: 10}}
+		engine = newEngine("anna")
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.State().Deadline).To(Equal(clock.Now().Add(10 * time.Second)))
+	})
+
+	It("Should ignore the timer of a completed turn", func() {
+		deck = deck[:2]
+		engine = newEngine("anna", "ben")
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.Complete("anna")).To(Succeed())
+		clock.Advance(30 * time.Second)
+		Expect(engine.State().Turn).To(Equal(2))
+	})
+
+	It("Should skip the turn of a player that leaves", func() {
+		engine.AddPlayer("carl")
+		Expect(engine.Start()).To(Succeed())
+		engine.RemovePlayer("anna")
+
+		Expect(out.states[1].Outcome).To(Equal(OutcomeSkipped))
+		Expect(engine.State().Player).To(Equal("ben"))
+		Expect(engine.State().Players).To(Equal([]string{"ben", "carl"}))
+	})
+
+	Context("with sip limits", func() {
+		allowances := map[string]int{}
+
+		BeforeEach(func() {
+			allowances = map[string]int{"anna": -1, "ben": 1}
+			engine = NewEngine("game", []string{"anna", "ben"}, deck, out, Options{
+				Clock:     clock,
+				Random:    rand.New(rand.NewSource(42)),
+				Allowance: func(player string) int { return allowances[player] },
+			})
+		})
+
+		It("Should only draw challenges within the allowance", func() {
+			Expect(engine.Start()).To(Succeed())
+			Expect(engine.State().Capped).To(BeFalse())
+			Expect(engine.Complete("anna")).To(Succeed())
+
+			state := engine.State()
+			Expect(state.Player).To(Equal("ben"))
+			Expect(state.Capped).To(BeTrue())
+			Expect(state.Challenge.Sips).To(BeNumerically("<=", 1))
+		})
+
+		It("Should substitute challenges for players in water mode", func() {
+			allowances["anna"] = 0
+			Expect(engine.Start()).To(Succeed())
+
+			state := engine.State()
+			Expect(state.Capped).To(BeTrue())
+			Expect(state.Challenge.Text).To(Equal(DefaultSubstitute.Text))
+			Expect(state.Challenge.Sips).To(BeZero())
+			Expect(state.Remaining).To(Equal(3))
+		})
+	})
+
+	It("Should return the running turn when stopped", func() {
+		Expect(engine.Start()).To(Succeed())
+		snapshot := engine.Stop()
+		Expect(snapshot.State.Phase).To(Equal(PhaseTurn))
+		Expect(snapshot.State.Player).To(Equal("anna"))
+
+		clock.Advance(time.Hour)
+		Expect(out.events).To(HaveLen(1))
+		Expect(engine.Skip()).To(Equal(ErrNotRunning))
+	})
+
+	It("Should give late players a turn", func() {
+		Expect(engine.Start()).To(Succeed())
+		engine.AddPlayer("carl")
+		Expect(engine.Complete("anna")).To(Succeed())
+		Expect(engine.Complete("ben")).To(Succeed())
+		Expect(engine.State().Player).To(Equal("carl"))
+	})
+
+	Context("restoring a game", func() {
+		var saved []Snapshot
+
+		BeforeEach(func() {
+			saved = nil
+			engine = NewEngine("game", []string{"anna", "ben"}, deck, out, Options{
+				Clock:   clock,
+				Random:  rand.New(rand.NewSource(42)),
+				Persist: func(snapshot Snapshot) { saved = append(saved, snapshot) },
+			})
+		})
+
+		It("Should persist every change", func() {
+			Expect(engine.Start()).To(Succeed())
+			engine.AddPlayer("carl")
+			Expect(engine.Complete("anna")).To(Succeed())
+
+			Expect(saved).To(HaveLen(3))
+			last := saved[2]
+			Expect(last).To(Equal(engine.Snapshot()))
+			Expect(last.State.Player).To(Equal("ben"))
+			Expect(last.State.Players).To(Equal([]string{"anna", "ben", "carl"}))
+			Expect(last.Drawn).To(HaveLen(2))
+			Expect(last.Next).To(Equal(2))
+		})
+
+		It("Should continue the turn with the time that was left", func() {
+			Expect(engine.Start()).To(Succeed())
+			snapshot := engine.Stop()
+			clock.Advance(time.Minute)
+
+			restored := Restore(snapshot, deck, out, Options{Clock: clock, TurnTimeout: 5 * time.Minute})
+			Expect(restored.State()).To(Equal(snapshot.State))
+			Expect(restored.Resume()).To(Succeed())
+			Expect(restored.Resume()).To(Equal(ErrAlreadyStarted))
+
+			clock.Advance(snapshot.State.Deadline.Sub(clock.Now()))
+			event, state := out.last()
+			Expect(event).To(Equal(EventTurnStarted))
+			Expect(state.Turn).To(Equal(2))
+			Expect(state.Player).To(Equal("ben"))
+			Expect(snapshot.Drawn).ToNot(ContainElement(state.Challenge.ID))
+		})
+
+		It("Should end a turn whose deadline passed while it was stopped", func() {
+			Expect(engine.Start()).To(Succeed())
+			snapshot := engine.Stop()
+			clock.Advance(time.Hour)
+
+			restored := Restore(snapshot, deck, out, Options{Clock: clock})
+			Expect(restored.Resume()).To(Succeed())
+			Expect(out.events).To(Equal([]string{EventTurnStarted, EventTurnEnded, EventTurnStarted}))
+			Expect(out.states[1].Outcome).To(Equal(OutcomeTimeout))
+			Expect(restored.State().Player).To(Equal("ben"))
+		})
+
+		It("Should not resume a game that is not in a turn", func() {
+			restored := Restore(engine.Snapshot(), deck, out, Options{Clock: clock})
+			Expect(restored.Resume()).To(Equal(ErrNotRunning))
+		})
+	})
+
+	It("Should apply the house rules to challenges and ended turns", func() {
+		rules, err := NewRules([]RuleSpec{
+			{Name: RuleDoubleAfterMidnight, Params: map[string]int{"from": 20, "until": 21}},
+			{Name: RuleWaterfall, Params: map[string]int{"every": 1}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		deck = []Challenge{{ID: "a", Sips: 2}}
+		engine = NewEngine("game", []string{"anna", "ben"}, deck, out, Options{Clock: clock, Rules: rules})
+		Expect(engine.Start()).To(Succeed())
+		Expect(engine.State().Challenge.Sips).To(Equal(4))
+		Expect(deck[0].Sips).To(Equal(2))
+
+		Expect(engine.Complete("anna")).To(Succeed())
+		Expect(out.events[1]).To(Equal(EventTurnEnded))
+		Expect(out.states[1].Effects).To(HaveLen(2))
+		_, state := out.last()
+		Expect(state.Effects).To(BeEmpty())
+	})
+
+	Context("pausing", func() {
+		BeforeEach(func() {
+			deck = deck[:2]
+			engine = newEngine("anna", "ben")
+		})
+
+		It("Should keep the time that was left", func() {
+			Expect(engine.Start()).To(Succeed())
+			clock.Advance(20 * time.Second)
+			Expect(engine.Pause()).To(Succeed())
+
+			clock.Advance(time.Hour)
+			Expect(engine.State().Turn).To(Equal(1))
+			Expect(engine.State().Paused).To(BeTrue())
+			Expect(engine.Complete("anna")).To(Equal(ErrPaused))
+			Expect(engine.Skip()).To(Equal(ErrPaused))
+
+			Expect(engine.Continue()).To(Succeed())
+			Expect(engine.Continue()).To(Equal(ErrNotPaused))
+			Expect(engine.State().Deadline).To(Equal(clock.Now().Add(40 * time.Second)))
+			Expect(out.events).To(Equal([]string{EventTurnStarted, EventGamePaused, EventGameResumed}))
+
+			clock.Advance(40 * time.Second)
+			Expect(out.states[3].Outcome).To(Equal(OutcomeTimeout))
+		})
+
+		It("Should not start the clock of the next player while paused", func() {
+			Expect(engine.Start()).To(Succeed())
+			Expect(engine.Pause()).To(Succeed())
+			engine.RemovePlayer("anna")
+
+			clock.Advance(time.Hour)
+			state := engine.State()
+			Expect(state.Player).To(Equal("ben"))
+			Expect(state.Paused).To(BeTrue())
+
+			Expect(engine.Continue()).To(Succeed())
+			Expect(engine.State().Deadline).To(Equal(clock.Now().Add(time.Minute)))
+		})
+
+		It("Should stay paused when restored", func() {
+			Expect(engine.Start()).To(Succeed())
+			clock.Advance(15 * time.Second)
+			Expect(engine.Pause()).To(Succeed())
+			snapshot := engine.Stop()
+
+			restored := Restore(snapshot, deck, out, Options{Clock: clock})
+			Expect(restored.Resume()).To(Succeed())
+			clock.Advance(time.Hour)
+			Expect(restored.Continue()).To(Succeed())
+			Expect(restored.State().Deadline).To(Equal(clock.Now().Add(45 * time.Second)))
+		})
+	})
+})
@@ -0,0 +1,333 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+//Names of the house rules a game can enable
+const (
+	RuleThumbMaster         = "thumb-master"
+	RuleWaterfall           = "waterfall"
+	RuleDoubleAfterMidnight = "double-after-midnight"
+	RuleLastVoterDrinks     = "last-voter-drinks"
+)
+
+//ThumbCategory is the category of challenges that make the player
+//the thumb master
+const ThumbCategory = "thumb"
+
+//MaxSips is the most a player drinks at once, house rules never
+//hand out more
+const MaxSips = 20
+
+const (
+	//maxFactor is the most a house rule multiplies sips with
+	maxFactor = 4
+	//maxEvery is the most turns between two waterfalls
+	maxEvery = 100
+)
+
+//Effect is what a house rule does to a player, an effect without sips
+//only gives the player a role like the thumb master
+type Effect struct {
+	Rule   string `json:"rule"`
+	Player string `json:"player"`
+	Sips   int    `json:"sips"`
+}
+
+//VoteOutcome is a closed voting round as the house rules see it
+type VoteOutcome struct {
+	Winner string
+	//Voters are in the order they voted
+	Voters []string
+	Sips   int
+}
+
+//Rule is a house rule, the engine and the vote resolver call its hooks
+type Rule interface {
+	//Sips changes the sips of a challenge or vote at that time
+	Sips(sips int, at time.Time) int
+	//TurnEnded is called with the state of every turn that ended
+	TurnEnded(state State, at time.Time) []Effect
+	//VoteClosed is called for every closed voting round
+	VoteClosed(vote VoteOutcome, at time.Time) []Effect
+}
+
+//RuleSpec enables a rule for a game, params left out use the defaults
+type RuleSpec struct {
+	Name   string         `json:"name"`
+	Params map[string]int `json:"params,omitempty"`
+}
+
+//ruleParams are the params of a spec with their defaults
+type ruleParams map[string]int
+
+//int returns the param or the default if it is not set, it fails
+//if the value is not between min and max
+func (p ruleParams) int(name string, value, min, max int) (int, error) {
+	if given, ok := p[name]; ok {
+		value = given
+	}
+
+	if value < min || value > max {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+
+	return value, nil
+}
+
+//check fails for params the rule does not know
+func (p ruleParams) check(known ...string) error {
+	for name := range p {
+		found := false
+		for _, k := range known {
+			found = found || k == name
+		}
+
+		if !found {
+			return fmt.Errorf("unknown param %s", name)
+		}
+	}
+
+	return nil
+}
+
+//ruleFactories build every known rule from its params
+var ruleFactories = map[string]func(ruleParams) (Rule, error){
+	RuleThumbMaster:         newThumbMaster,
+	RuleWaterfall:           newWaterfall,
+	RuleDoubleAfterMidnight: newDoubleAfterMidnight,
+	RuleLastVoterDrinks:     newLastVoterDrinks,
+}
+
+//NewRule returns the rule of the spec
+func NewRule(spec RuleSpec) (Rule, error) {
+	factory, ok := ruleFactories[spec.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown house rule %s", spec.Name)
+	}
+
+	rule, err := factory(ruleParams(spec.Params))
+	if err != nil {
+		return nil, fmt.Errorf("house rule %s: %s", spec.Name, err)
+	}
+
+	return rule, nil
+}
+
+//Rules are the house rules of a game in the order they were enabled
+type Rules []Rule
+
+//NewRules returns the rules of all specs, a rule can only be enabled once
+func NewRules(specs []RuleSpec) (Rules, error) {
+	var rules Rules
+	enabled := map[string]bool{}
+	for _, spec := range specs {
+		if enabled[spec.Name] {
+			return nil, fmt.Errorf("house rule %s is enabled twice", spec.Name)
+		}
+
+		rule, err := NewRule(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		enabled[spec.Name] = true
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+//Sips applies the sips hook of every rule
+func (r Rules) Sips(sips int, at time.Time) int {
+	for _, rule := range r {
+		sips = rule.Sips(sips, at)
+	}
+
+	return sips
+}
+
+//TurnEnded collects the effects of every rule
+func (r Rules) TurnEnded(state State, at time.Time) []Effect {
+	var effects []Effect
+	for _, rule := range r {
+		effects = append(effects, rule.TurnEnded(state, at)...)
+	}
+
+	return effects
+}
+
+//VoteClosed collects the effects of every rule
+func (r Rules) VoteClosed(vote VoteOutcome, at time.Time) []Effect {
+	var effects []Effect
+	for _, rule := range r {
+		effects = append(effects, rule.VoteClosed(vote, at)...)
+	}
+
+	return effects
+}
+
+//noHooks is embedded by rules so they only implement the hooks they need
+type noHooks struct{}
+
+func (noHooks) Sips(sips int, at time.Time) int {
+	return sips
+}
+
+func (noHooks) TurnEnded(state State, at time.Time) []Effect {
+	return nil
+}
+
+func (noHooks) VoteClosed(vote VoteOutcome, at time.Time) []Effect {
+	return nil
+}
+
+//thumbMaster makes the player that completes a thumb challenge the
+//thumb master, the players settle who was the last to follow the thumb
+type thumbMaster struct {
+	noHooks
+}
+
+func newThumbMaster(params ruleParams) (Rule, error) {
+	if err := params.check(); err != nil {
+		return nil, err
+	}
+
+	return thumbMaster{}, nil
+}
+
+func (thumbMaster) TurnEnded(state State, at time.Time) []Effect {
+	if state.Outcome != OutcomeCompleted || state.Challenge == nil || state.Challenge.Category != ThumbCategory {
+		return nil
+	}
+
+	return []Effect{{Rule: RuleThumbMaster, Player: state.Player}}
+}
+
+//waterfall lets every player drink sips after every turn that is a
+//multiple of every, by default after the 7th, 14th and so on
+type waterfall struct {
+	noHooks
+	every int
+	sips  int
+}
+
+func newWaterfall(params ruleParams) (Rule, error) {
+	if err := params.check("every", "sips"); err != nil {
+		return nil, err
+	}
+
+	every, err := params.int("every", 7, 1, maxEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	sips, err := params.int("sips", 1, 1, MaxSips)
+	if err != nil {
+		return nil, err
+	}
+
+	return waterfall{every: every, sips: sips}, nil
+}
+
+func (w waterfall) TurnEnded(state State, at time.Time) []Effect {
+	if state.Turn == 0 || state.Turn%w.every != 0 {
+		return nil
+	}
+
+	effects := make([]Effect, 0, len(state.Players))
+	for _, player := range state.Players {
+		effects = append(effects, Effect{Rule: RuleWaterfall, Player: player, Sips: w.sips})
+	}
+
+	return effects
+}
+
+//doubleAfterMidnight multiplies all sips by factor between the from and
+//until hour of the server time, by default from midnight until 6am.
+//A challenge never asks for more than MaxSips.
+type doubleAfterMidnight struct {
+	noHooks
+	from   int
+	until  int
+	factor int
+}
+
+func newDoubleAfterMidnight(params ruleParams) (Rule, error) {
+	if err := params.check("from", "until", "factor"); err != nil {
+		return nil, err
+	}
+
+	rule := doubleAfterMidnight{}
+	var err error
+	if rule.from, err = params.int("from", 0, 0, 23); err != nil {
+		return nil, err
+	}
+
+	if rule.until, err = params.int("until", 6, 0, 24); err != nil {
+		return nil, err
+	}
+
+	if rule.factor, err = params.int("factor", 2, 1, maxFactor); err != nil {
+		return nil, err
+	}
+
+	if rule.from == rule.until {
+		return nil, fmt.Errorf("from and until must be different hours of the day")
+	}
+
+	return rule, nil
+}
+
+//applies checks if the hour of at is within the window, which may
+//span midnight like from 22 until 4
+func (d doubleAfterMidnight) applies(at time.Time) bool {
+	hour := at.Hour()
+	if d.from < d.until {
+		return hour >= d.from && hour < d.until
+	}
+
+	return hour >= d.from || hour < d.until
+}
+
+func (d doubleAfterMidnight) Sips(sips int, at time.Time) int {
+	if !d.applies(at) {
+		return sips
+	}
+
+	if sips*d.factor > MaxSips {
+		return MaxSips
+	}
+
+	return sips * d.factor
+}
+
+//lastVoterDrinks lets the player that voted last drink sips, rounds
+//with a single voter have no last voter
+type lastVoterDrinks struct {
+	noHooks
+	sips int
+}
+
+func newLastVoterDrinks(params ruleParams) (Rule, error) {
+	if err := params.check("sips"); err != nil {
+		return nil, err
+	}
+
+	sips, err := params.int("sips", 1, 1, MaxSips)
+	if err != nil {
+		return nil, err
+	}
+
+	return lastVoterDrinks{sips: sips}, nil
+}
+
+func (l lastVoterDrinks) VoteClosed(vote VoteOutcome, at time.Time) []Effect {
+	if len(vote.Voters) < 2 {
+		return nil
+	}
+
+	return []Effect{{Rule: RuleLastVoterDrinks, Player: vote.Voters[len(vote.Voters)-1], Sips: l.sips}}
+}
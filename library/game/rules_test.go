@@ -0,0 +1,95 @@
+package game
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	evening := time.Date(2015, 8, 1, 20, 0, 0, 0, time.UTC)
+	night := time.Date(2015, 8, 2, 1, 0, 0, 0, time.UTC)
+
+	rule := func(name string, params map[string]int) Rule {
+		rule, err := NewRule(RuleSpec{Name: name, Params: params})
+		Expect(err).ToNot(HaveOccurred())
+		return rule
+	}
+
+	It("Should refuse unknown rules, params and values", func() {
+		_, err := NewRule(RuleSpec{Name: "beer-pong"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleWaterfall, Params: map[string]int{"often": 3}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleWaterfall, Params: map[string]int{"every": 0}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleDoubleAfterMidnight, Params: map[string]int{"from": 6}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleDoubleAfterMidnight, Params: map[string]int{"factor": 1000000}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleWaterfall, Params: map[string]int{"sips": MaxSips + 1}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRule(RuleSpec{Name: RuleLastVoterDrinks, Params: map[string]int{"sips": MaxSips + 1}})
+		Expect(err).To(HaveOccurred())
+		_, err = NewRules([]RuleSpec{{Name: RuleThumbMaster}, {Name: RuleThumbMaster}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should make the player of a completed thumb challenge the thumb master", func() {
+		thumb := rule(RuleThumbMaster, nil)
+		state := State{Player: "anna", Outcome: OutcomeCompleted, Challenge: &Challenge{Category: ThumbCategory}}
+		Expect(thumb.TurnEnded(state, evening)).To(Equal([]Effect{{Rule: RuleThumbMaster, Player: "anna"}}))
+
+		state.Outcome = OutcomeTimeout
+		Expect(thumb.TurnEnded(state, evening)).To(BeEmpty())
+		state.Outcome, state.Challenge = OutcomeCompleted, &Challenge{Category: "dare"}
+		Expect(thumb.TurnEnded(state, evening)).To(BeEmpty())
+	})
+
+	It("Should let everyone drink on every seventh turn", func() {
+		waterfall := rule(RuleWaterfall, map[string]int{"sips": 2})
+		state := State{Turn: 6, Players: []string{"anna", "ben"}}
+		Expect(waterfall.TurnEnded(state, evening)).To(BeEmpty())
+
+		state.Turn = 14
+		Expect(waterfall.TurnEnded(state, evening)).To(Equal([]Effect{
+			{Rule: RuleWaterfall, Player: "anna", Sips: 2},
+			{Rule: RuleWaterfall, Player: "ben", Sips: 2},
+		}))
+	})
+
+	It("Should double sips after midnight", func() {
+		double := rule(RuleDoubleAfterMidnight, nil)
+		Expect(double.Sips(3, evening)).To(Equal(3))
+		Expect(double.Sips(3, night)).To(Equal(6))
+
+		late := rule(RuleDoubleAfterMidnight, map[string]int{"from": 20, "until": 2, "factor": 3})
+		Expect(late.Sips(1, evening)).To(Equal(3))
+		Expect(late.Sips(1, night)).To(Equal(3))
+		Expect(late.Sips(1, evening.Add(-time.Hour))).To(Equal(1))
+		Expect(late.Sips(MaxSips, night)).To(Equal(MaxSips))
+	})
+
+	It("Should let the last voter drink", func() {
+		last := rule(RuleLastVoterDrinks, nil)
+		Expect(last.VoteClosed(VoteOutcome{Voters: []string{"anna"}}, evening)).To(BeEmpty())
+		Expect(last.VoteClosed(VoteOutcome{Voters: []string{"anna", "ben"}}, evening)).To(Equal([]Effect{
+			{Rule: RuleLastVoterDrinks, Player: "ben", Sips: 1},
+		}))
+	})
+
+	It("Should combine the hooks of all rules", func() {
+		rules, err := NewRules([]RuleSpec{
+			{Name: RuleDoubleAfterMidnight},
+			{Name: RuleWaterfall, Params: map[string]int{"every": 1}},
+			{Name: RuleLastVoterDrinks},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(rules.Sips(2, night)).To(Equal(4))
+		Expect(rules.TurnEnded(State{Turn: 1, Players: []string{"anna"}}, night)).To(HaveLen(1))
+		Expect(rules.VoteClosed(VoteOutcome{Voters: []string{"anna", "ben"}}, night)).To(HaveLen(1))
+		Expect(Rules(nil).Sips(2, night)).To(Equal(2))
+	})
+})
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//statusRecorder remembers the status code of a response,
+//it can still be hijacked for websockets
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+//Hijack satisfies the http.Hijacker interface
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The response can not be hijacked")
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+//Flush satisfies the http.Flusher interface
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//HTTP counts requests and their duration, requests are labeled with
+//the first of the prefixes their path starts with
+type HTTP struct {
+	requests *Counter
+	duration *Histogram
+	prefixes []string
+}
+
+//NewHTTP registers the request metrics
+func (r *Registry) NewHTTP(prefixes ...string) *HTTP {
+	return &HTTP{
+		requests: r.NewCounter("soyfr_http_requests_total", "Number of http requests by handler, method and status code."),
+		duration: r.NewHistogram("soyfr_http_request_duration_seconds", "Duration of http requests by handler.", DefaultBuckets),
+		prefixes: prefixes,
+	}
+}
+
+//handler returns the label of a path, paths without a known prefix
+//are labeled other to keep the number of series small
+func (h *HTTP) handler(path string) string {
+	for _, prefix := range h.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return prefix
+		}
+	}
+
+	return "other"
+}
+
+//Instrument measures every request of next
+func (h *HTTP) Instrument(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler := h.handler(r.URL.Path)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		h.requests.Inc(Labels{"handler": handler, "method": r.Method, "code": strconv.Itoa(status)})
+		h.duration.Observe(Labels{"handler": handler}, time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//labelEscaper escapes label values as the text format expects
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+//Labels are the label values of a sample by label name
+type Labels map[string]string
+
+//String formats the labels as prometheus expects them
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+labelEscaper.Replace(l[name])+"\"")
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+//with returns a copy of the labels with one more label
+func (l Labels) with(name, value string) Labels {
+	labels := Labels{name: value}
+	for key, v := range l {
+		labels[key] = v
+	}
+
+	return labels
+}
+
+//Sample is a single value of a metric
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+//metric writes its samples in the text format
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+//Registry keeps all metrics of the application and serves them
+//in the prometheus text format
+type Registry struct {
+	sync.Mutex
+	metrics map[string]metric
+	help    map[string]string
+	kinds   map[string]string
+}
+
+//NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+		help:    make(map[string]string),
+		kinds:   make(map[string]string),
+	}
+}
+
+//register adds a metric, names must be unique
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metric %s is registered twice", name))
+	}
+
+	r.metrics[name] = m
+	r.help[name] = help
+	r.kinds[name] = kind
+}
+
+//Expose writes all metrics ordered by name
+func (r *Registry) Expose(w io.Writer) {
+	r.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	r.Unlock()
+
+	for _, name := range names {
+		r.Lock()
+		m, help, kind := r.metrics[name], r.help[name], r.kinds[name]
+		r.Unlock()
+
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+		m.write(w, name)
+	}
+}
+
+//ServeHTTP serves the metrics for the prometheus scraper
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Expose(w)
+}
+
+//formatValue writes integers without a fraction
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+//Counter only goes up, it keeps one value per label set
+type Counter struct {
+	sync.Mutex
+	values map[string]*Sample
+}
+
+//NewCounter registers a counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{values: make(map[string]*Sample)}
+	r.register(name, help, "counter", c)
+
+	return c
+}
+
+//Add increases the counter of the labels
+func (c *Counter) Add(labels Labels, value float64) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := labels.String()
+	if _, ok := c.values[key]; !ok {
+		c.values[key] = &Sample{Labels: labels}
+	}
+
+	c.values[key].Value += value
+}
+
+//Inc increases the counter of the labels by one
+func (c *Counter) Inc(labels Labels) {
+	c.Add(labels, 1)
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatValue(c.values[key].Value))
+	}
+}
+
+//Histogram counts observations in buckets, it keeps one set of
+//buckets per label set
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels Labels
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+//DefaultBuckets are meant for request durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//NewHistogram registers a histogram, buckets must be sorted
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{buckets: buckets, series: make(map[string]*histogramSeries)}
+	r.register(name, help, "histogram", h)
+
+	return h
+}
+
+//Observe adds a value to the buckets of the labels
+func (h *Histogram) Observe(labels Labels, value float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	key := labels.String()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labels: labels, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+
+	series.count++
+	series.sum += value
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	h.Lock()
+	defer h.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			labels := series.labels.with("le", formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, series.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, series.labels.with("le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, key, formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, key, series.count)
+	}
+}
+
+//sampleFunc reads its samples when the metrics are scraped
+type sampleFunc func() []Sample
+
+func (f sampleFunc) write(w io.Writer, name string) {
+	samples := f()
+	sort.Sort(byLabels(samples))
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, sample.Labels, formatValue(sample.Value))
+	}
+}
+
+//NewGaugeFunc registers a gauge whose samples are read on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, samples func() []Sample) {
+	r.register(name, help, "gauge", sampleFunc(samples))
+}
+
+//NewCounterFunc registers a counter that is kept elsewhere,
+//its samples are read on every scrape
+func (r *Registry) NewCounterFunc(name, help string, samples func() []Sample) {
+	r.register(name, help, "counter", sampleFunc(samples))
+}
+
+type byLabels []Sample
+
+func (s byLabels) Len() int           { return len(s) }
+func (s byLabels) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLabels) Less(i, j int) bool { return s[i].Labels.String() < s[j].Labels.String() }
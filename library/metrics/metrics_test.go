@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var registry *Registry
+
+	expose := func() string {
+		out := &bytes.Buffer{}
+		registry.Expose(out)
+		return out.String()
+	}
+
+	BeforeEach(func() {
+		registry = NewRegistry()
+	})
+
+	It("Should write counters with sorted labels", func() {
+		counter := registry.NewCounter("unit_total", "Unit counter.")
+		counter.Inc(Labels{"b": "2", "a": "1"})
+		counter.Add(Labels{"a": "1", "b": "2"}, 2)
+		counter.Inc(Labels{"a": `say "hi"`})
+
+		Expect(expose()).To(Equal(`# HELP unit_total Unit counter.
+# TYPE unit_total counter
+unit_total{a="1",b="2"} 3
+unit_total{a="say \"hi\""} 1
+`))
+	})
+
+	It("Should write cumulative histogram buckets", func() {
+		histogram := registry.NewHistogram("unit_seconds", "Unit histogram.", []float64{0.1, 1})
+		histogram.Observe(nil, 0.05)
+		histogram.Observe(nil, 0.5)
+		histogram.Observe(nil, 5)
+
+		Expect(expose()).To(Equal(`# HELP unit_seconds Unit histogram.
+# TYPE unit_seconds histogram
+unit_seconds_bucket{le="0.1"} 1
+unit_seconds_bucket{le="1"} 2
+unit_seconds_bucket{le="+Inf"} 3
+unit_seconds_sum 5.55
+unit_seconds_count 3
+`))
+	})
+
+	It("Should read gauges on every scrape", func() {
+		value := 1.0
+		registry.NewGaugeFunc("unit_gauge", "Unit gauge.", func() []Sample {
+			return []Sample{{Labels: Labels{"room": "b"}, Value: value}, {Labels: Labels{"room": "a"}, Value: 2}}
+		})
+
+		Expect(expose()).To(ContainSubstring("unit_gauge{room=\"a\"} 2\nunit_gauge{room=\"b\"} 1\n"))
+		value = 3
+		Expect(expose()).To(ContainSubstring("unit_gauge{room=\"b\"} 3\n"))
+	})
+
+	It("Should refuse metrics with the same name", func() {
+		registry.NewCounter("unit_total", "Unit counter.")
+		Expect(func() { registry.NewCounter("unit_total", "Again.") }).To(Panic())
+	})
+
+	It("Should count requests by handler and status", func() {
+		requests := registry.NewHTTP("/api/")
+		handler := requests.Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/missing" {
+				http.NotFound(w, r)
+				return
+			}
+
+			w.Write([]byte("ok"))
+		}))
+
+		for _, path := range []string{"/api/users", "/api/missing", "/index.html"} {
+			r, _ := http.NewRequest("GET", path, nil)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+
+		text := expose()
+		Expect(text).To(ContainSubstring(`soyfr_http_requests_total{code="200",handler="/api/",method="GET"} 1`))
+		Expect(text).To(ContainSubstring(`soyfr_http_requests_total{code="404",handler="/api/",method="GET"} 1`))
+		Expect(text).To(ContainSubstring(`soyfr_http_requests_total{code="200",handler="other",method="GET"} 1`))
+		Expect(text).To(ContainSubstring(`soyfr_http_request_duration_seconds_count{handler="/api/"} 2`))
+	})
+})
@@ -0,0 +1,143 @@
+package migration
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	//Collection keeps a record of every applied migration
+	Collection = "migrations"
+	//mongeezCollection is where mongeez recorded its changesets
+	mongeezCollection = "mongeez"
+)
+
+//Step is a single change of the database scheme, exactly one
+//of Index, Create and Remove is set
+type Step struct {
+	Collection string
+	Index      *mgo.Index
+	Create     *mgo.CollectionInfo
+	Remove     bson.M
+}
+
+//String describes the step, the checksum of a migration is built from it
+func (s Step) String() string {
+	switch {
+	case s.Index != nil:
+		description := fmt.Sprintf("%s: index %s", s.Collection, strings.Join(s.Index.Key, ","))
+		if s.Index.Unique {
+			description += " unique"
+		}
+
+		if s.Index.Sparse {
+			description += " sparse"
+		}
+
+		if s.Index.ExpireAfter > 0 {
+			description += fmt.Sprintf(" expire after %s", s.Index.ExpireAfter)
+		}
+
+		return description
+	case s.Create != nil:
+		description := fmt.Sprintf("%s: create", s.Collection)
+		if s.Create.Capped {
+			description += fmt.Sprintf(" capped %d bytes %d documents", s.Create.MaxBytes, s.Create.MaxDocs)
+		}
+
+		return description
+	case s.Remove != nil:
+		filter, _ := json.Marshal(s.Remove)
+		return fmt.Sprintf("%s: remove %s", s.Collection, filter)
+	}
+
+	return fmt.Sprintf("%s: nothing", s.Collection)
+}
+
+//apply runs the step on the database
+func (s Step) apply(database *mgo.Database) error {
+	collection := database.C(s.Collection)
+	switch {
+	case s.Index != nil:
+		return collection.EnsureIndex(*s.Index)
+	case s.Create != nil:
+		err := collection.Create(s.Create)
+		if err != nil && strings.Contains(err.Error(), "already exists") {
+			return nil
+		}
+
+		return err
+	case s.Remove != nil:
+		_, err := collection.RemoveAll(s.Remove)
+		return err
+	}
+
+	return nil
+}
+
+//Migration is a named list of steps, the ids follow the author:name
+//format of the former mongeez changesets
+type Migration struct {
+	ID    string
+	Steps []Step
+}
+
+//Checksum changes whenever a step of the migration is changed
+func (m Migration) Checksum() string {
+	hash := sha256.New()
+	for _, step := range m.Steps {
+		fmt.Fprintln(hash, step.String())
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//Record is stored for every applied migration, adopted migrations
+//were applied by mongeez and never run again
+type Record struct {
+	ID       string    `bson:"_id"`
+	Checksum string    `bson:"checksum"`
+	Applied  time.Time `bson:"applied"`
+	Adopted  bool      `bson:"adopted,omitempty"`
+}
+
+//Status tells if a migration was applied and if it changed since
+type Status struct {
+	ID       string
+	Checksum string
+	Applied  *time.Time
+	Changed  bool
+}
+
+//Plan returns the migrations that were not applied yet in order. It fails
+//if ids are not unique or an applied migration was changed afterwards.
+func Plan(records map[string]Record, migrations []Migration) ([]Migration, error) {
+	var pending []Migration
+	seen := map[string]bool{}
+	for _, migration := range migrations {
+		if seen[migration.ID] {
+			return nil, fmt.Errorf("migration %s is defined twice", migration.ID)
+		}
+
+		seen[migration.ID] = true
+
+		record, applied := records[migration.ID]
+		if !applied {
+			pending = append(pending, migration)
+			continue
+		}
+
+		if record.Checksum != migration.Checksum() {
+			return nil, fmt.Errorf("migration %s was changed after it was applied", migration.ID)
+		}
+	}
+
+	return pending, nil
+}
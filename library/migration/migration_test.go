@@ -0,0 +1,131 @@
+package migration
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ = Describe("Migration", func() {
+	var migrations []Migration
+
+	BeforeEach(func() {
+		migrations = []Migration{
+			{ID: "unit:users", Steps: []Step{
+				{Collection: "user", Index: &mgo.Index{Key: []string{"username"}, Unique: true}},
+			}},
+			{ID: "unit:events", Steps: []Step{
+				{Collection: "event", Create: &mgo.CollectionInfo{Capped: true, MaxBytes: 4096}},
+				{Collection: "event", Remove: bson.M{"old": true}},
+			}},
+		}
+	})
+
+	It("Should describe every step", func() {
+		Expect(migrations[0].Steps[0].String()).To(Equal("user: index username unique"))
+		Expect(migrations[1].Steps[0].String()).To(Equal("event: create capped 4096 bytes 0 documents"))
+		Expect(migrations[1].Steps[1].String()).To(Equal(`event: remove {"old":true}`))
+	})
+
+	It("Should change the checksum with the steps", func() {
+		checksum := migrations[0].Checksum()
+		Expect(checksum).To(HaveLen(64))
+		Expect(migrations[0].Checksum()).To(Equal(checksum))
+
+		migrations[0].Steps[0].Index.Sparse = true
+		Expect(migrations[0].Checksum()).ToNot(Equal(checksum))
+	})
+
+	It("Should plan the migrations that were not applied", func() {
+		pending, err := Plan(map[string]Record{}, migrations)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(2))
+
+		records := map[string]Record{"unit:users": {ID: "unit:users", Checksum: migrations[0].Checksum()}}
+		pending, err = Plan(records, migrations)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].ID).To(Equal("unit:events"))
+	})
+
+	It("Should refuse applied migrations that were changed", func() {
+		records := map[string]Record{"unit:users": {ID: "unit:users", Checksum: "before"}}
+		_, err := Plan(records, migrations)
+		Expect(err).To(MatchError("migration unit:users was changed after it was applied"))
+	})
+
+	It("Should refuse duplicate ids", func() {
+		_, err := Plan(map[string]Record{}, append(migrations, migrations[0]))
+		Expect(err).To(MatchError("migration unit:users is defined twice"))
+	})
+
+	Context("running migrations", func() {
+		var session *mgo.Session
+		var runner Runner
+
+		BeforeEach(func() {
+			var err error
+			session, err = mgo.Dial("localhost")
+			Expect(err).ToNot(HaveOccurred())
+			runner = Runner{Database: session.DB("soyfer_migration_test"), Migrations: migrations}
+		})
+
+		It("Should only report pending migrations in a dry run", func() {
+			out := &bytes.Buffer{}
+			runner.DryRun = true
+			runner.Out = out
+
+			applied, err := runner.Up()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+			Expect(out.String()).To(ContainSubstring("user: index username unique"))
+
+			statuses, err := runner.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses[0].Applied).To(BeNil())
+		})
+
+		It("Should apply every migration once", func() {
+			applied, err := runner.Up()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(2))
+
+			applied, err = runner.Up()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(BeEmpty())
+
+			statuses, err := runner.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses[1].Applied).ToNot(BeNil())
+			Expect(statuses[1].Changed).To(BeFalse())
+		})
+
+		It("Should take over changesets of mongeez", func() {
+			err := runner.Database.C(mongeezCollection).Insert(bson.M{
+				"type":     "changeSetExecution",
+				"author":   "unit",
+				"changeId": "users",
+				"date":     "2015-08-01T20:00:00.000+02:00",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			applied, err := runner.Up()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(applied).To(HaveLen(1))
+
+			count, err := runner.Database.C(Collection).FindId("unit:users").Count()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(1))
+		})
+
+		AfterEach(func() {
+			if session != nil {
+				runner.Database.DropDatabase()
+				session.Close()
+			}
+		})
+	})
+})
@@ -0,0 +1,150 @@
+package migration
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//mongeezDate is the format of the dates mongeez recorded
+const mongeezDate = "2006-01-02T15:04:05.000-07:00"
+
+//Runner applies migrations to a database
+type Runner struct {
+	Database   *mgo.Database
+	Migrations []Migration
+	//DryRun only reports what would be applied
+	DryRun bool
+	//Out receives a line for every step, it may be nil
+	Out io.Writer
+}
+
+//out returns the writer for progress messages
+func (r Runner) out() io.Writer {
+	if r.Out == nil {
+		return ioutil.Discard
+	}
+
+	return r.Out
+}
+
+//records returns all applied migrations, changesets that only mongeez
+//recorded are adopted with the checksum of their migration
+func (r Runner) records() (map[string]Record, error) {
+	records := map[string]Record{}
+
+	var stored []Record
+	if err := r.Database.C(Collection).Find(nil).All(&stored); err != nil {
+		return nil, err
+	}
+
+	for _, record := range stored {
+		records[record.ID] = record
+	}
+
+	//mongeez stores the date as joda time string
+	var changesets []struct {
+		Author   string `bson:"author"`
+		ChangeID string `bson:"changeId"`
+		Date     string `bson:"date"`
+	}
+
+	query := bson.M{"type": "changeSetExecution"}
+	if err := r.Database.C(mongeezCollection).Find(query).All(&changesets); err != nil {
+		return nil, err
+	}
+
+	for _, changeset := range changesets {
+		id := changeset.Author + ":" + changeset.ChangeID
+		if _, known := records[id]; known {
+			continue
+		}
+
+		for _, migration := range r.Migrations {
+			if migration.ID == id {
+				applied, _ := time.Parse(mongeezDate, changeset.Date)
+				records[id] = Record{ID: id, Checksum: migration.Checksum(), Applied: applied, Adopted: true}
+			}
+		}
+	}
+
+	return records, nil
+}
+
+//Status returns the state of all migrations in order
+func (r Runner) Status() ([]Status, error) {
+	records, err := r.records()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range r.Migrations {
+		status := Status{ID: migration.ID, Checksum: migration.Checksum()}
+		if record, applied := records[migration.ID]; applied {
+			appliedAt := record.Applied
+			status.Applied = &appliedAt
+			status.Changed = record.Checksum != status.Checksum
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+//Up applies all pending migrations in order and returns them, nothing
+//is changed if an applied migration was modified afterwards
+func (r Runner) Up() ([]Migration, error) {
+	records, err := r.records()
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := Plan(records, r.Migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.DryRun {
+		//changesets of mongeez are recorded so it no longer matters
+		for _, record := range records {
+			if !record.Adopted {
+				continue
+			}
+
+			if _, err := r.Database.C(Collection).UpsertId(record.ID, record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, migration := range pending {
+		fmt.Fprintf(r.out(), "%s\n", migration.ID)
+		for _, step := range migration.Steps {
+			fmt.Fprintf(r.out(), "  %s\n", step)
+			if r.DryRun {
+				continue
+			}
+
+			if err := step.apply(r.Database); err != nil {
+				return pending[:i], fmt.Errorf("migration %s failed: %s", migration.ID, err)
+			}
+		}
+
+		if r.DryRun {
+			continue
+		}
+
+		record := Record{ID: migration.ID, Checksum: migration.Checksum(), Applied: time.Now()}
+		if err := r.Database.C(Collection).Insert(record); err != nil {
+			return pending[:i], err
+		}
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/manyminds/soyfr/library/config"
+	"github.com/manyminds/soyfr/library/db"
+	"github.com/maxwellhealth/bongo"
+)
+
+//connect opens the configured database
+func connect(conf config.Config) *bongo.Connection {
+	connection, err := bongo.Connect(conf.Bongo())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return connection
+}
+
+//importDeckCommand loads decks from json or yaml files, decks that
+//already exist are replaced
+func importDeckCommand(conf *config.Config) cli.Command {
+	return cli.Command{
+		Name:        "import-deck",
+		Usage:       "import decks from json or yaml files",
+		Description: "soyfr import-deck decks/classic.yml decks/party.json",
+		Action: func(c *cli.Context) {
+			if len(c.Args()) == 0 {
+				cli.ShowCommandHelp(c, "import-deck")
+				return
+			}
+
+			var decks []db.DeckFile
+			for _, path := range c.Args() {
+				fileDecks, err := db.ReadDeckFile(path)
+				if err != nil {
+					log.Fatalf("%s: %s", path, err)
+				}
+
+				decks = append(decks, fileDecks...)
+			}
+
+			connection := connect(*conf)
+			defer connection.Session.Close()
+
+			if err := db.ImportDecks(connection, decks); err != nil {
+				log.Fatal(err)
+			}
+
+			for _, deck := range decks {
+				log.Printf("Imported deck %s with %d challenges\n", deck.Name, len(deck.Challenges))
+			}
+		},
+	}
+}
+
+//serveCommand starts the server, it is also run without a command
+func serveCommand(conf *config.Config) cli.Command {
+	return cli.Command{
+		Name:  "serve",
+		Usage: "start the server",
+		Action: func(c *cli.Context) {
+			serve(*conf)
+		},
+	}
+}
+
+//migrate applies all pending migrations
+func migrate(conf config.Config, dryRun bool) {
+	connection := connect(conf)
+	defer connection.Session.Close()
+
+	runner := db.MigrationRunner(connection)
+	runner.DryRun = dryRun
+	runner.Out = os.Stdout
+
+	applied, err := runner.Up()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if dryRun {
+		log.Printf("%d migrations would be applied\n", len(applied))
+		return
+	}
+
+	log.Printf("Applied %d migrations\n", len(applied))
+}
+
+//migrateCommand updates the database scheme, it runs up without a subcommand
+func migrateCommand(conf *config.Config) cli.Command {
+	dryRunFlag := cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only show the pending migrations",
+	}
+
+	up := func(c *cli.Context) {
+		migrate(*conf, c.Bool("dry-run"))
+	}
+
+	return cli.Command{
+		Name:   "migrate",
+		Usage:  "update the database scheme",
+		Flags:  []cli.Flag{dryRunFlag},
+		Action: up,
+		Subcommands: []cli.Command{
+			{
+				Name:   "up",
+				Usage:  "apply all pending migrations",
+				Flags:  []cli.Flag{dryRunFlag},
+				Action: up,
+			},
+			{
+				Name:  "status",
+				Usage: "list all migrations and when they were applied",
+				Action: func(c *cli.Context) {
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					statuses, err := db.MigrationRunner(connection).Status()
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					for _, status := range statuses {
+						state := "pending"
+						if status.Applied != nil {
+							state = status.Applied.Format(time.RFC3339)
+						}
+
+						if status.Changed {
+							state += " changed"
+						}
+
+						fmt.Printf("%s\t%s\t%s\n", status.Checksum[:12], state, status.ID)
+					}
+				},
+			},
+		},
+	}
+}
+
+//seedCommand creates the demo users and imports all decks of a folder
+func seedCommand(conf *config.Config) cli.Command {
+	return cli.Command{
+		Name:  "seed",
+		Usage: "load demo users and decks",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "decks",
+				Value: "decks",
+				Usage: "folder with json and yaml decks",
+			},
+			cli.StringFlag{
+				Name:  "password",
+				Value: "soyfr-demo",
+				Usage: "password of the demo users",
+			},
+		},
+		Action: func(c *cli.Context) {
+			var decks []db.DeckFile
+			for _, pattern := range []string{"*.json", "*.yml", "*.yaml"} {
+				paths, err := filepath.Glob(filepath.Join(c.String("decks"), pattern))
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				for _, path := range paths {
+					fileDecks, err := db.ReadDeckFile(path)
+					if err != nil {
+						log.Fatalf("%s: %s", path, err)
+					}
+
+					decks = append(decks, fileDecks...)
+				}
+			}
+
+			connection := connect(*conf)
+			defer connection.Session.Close()
+
+			users, err := db.SeedUsers(connection, c.String("password"))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, user := range users {
+				log.Printf("Created demo user %s\n", user.Username)
+			}
+
+			if err := db.ImportDecks(connection, decks); err != nil {
+				log.Fatal(err)
+			}
+
+			log.Printf("Imported %d decks\n", len(decks))
+		},
+	}
+}
+
+//readPassword returns the password flag or reads it from stdin
+func readPassword(c *cli.Context) string {
+	if password := c.String("password"); password != "" {
+		return password
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatal(err)
+	}
+
+	return strings.TrimRight(line, "\r\n")
+}
+
+//userCommand lets operators manage users without the api
+func userCommand(conf *config.Config) cli.Command {
+	passwordFlag := cli.StringFlag{
+		Name:  "password",
+		Usage: "the password, it is read from stdin if not given",
+	}
+
+	return cli.Command{
+		Name:  "user",
+		Usage: "manage users and their passwords",
+		Subcommands: []cli.Command{
+			{
+				Name:  "list",
+				Usage: "list all users",
+				Action: func(c *cli.Context) {
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					users, err := db.ListUsers(connection)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					for _, user := range users {
+						kind := "user"
+						if user.Guest {
+							kind = "guest"
+						} else if user.Admin {
+							kind = "admin"
+						}
+
+						fmt.Printf("%s\t%s\t%s\t%s\n", user.ID.Hex(), kind, user.Username, user.Nickname)
+					}
+				},
+			},
+			{
+				Name:        "create",
+				Usage:       "create a user with a password",
+				Description: "soyfr user create --nickname Anna anna",
+				Flags: []cli.Flag{
+					passwordFlag,
+					cli.StringFlag{Name: "nickname", Usage: "the nickname of the user"},
+				},
+				Action: func(c *cli.Context) {
+					if len(c.Args()) != 1 {
+						cli.ShowCommandHelp(c, "create")
+						return
+					}
+
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					user, err := db.CreateUser(connection, c.Args().First(), c.String("nickname"), readPassword(c))
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					log.Printf("Created user %s with id %s\n", user.Username, user.ID.Hex())
+				},
+			},
+			{
+				Name:  "delete",
+				Usage: "delete a user",
+				Action: func(c *cli.Context) {
+					if len(c.Args()) != 1 {
+						cli.ShowCommandHelp(c, "delete")
+						return
+					}
+
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					if err := db.DeleteUser(connection, c.Args().First()); err != nil {
+						log.Fatal(err)
+					}
+
+					log.Printf("Deleted user %s\n", c.Args().First())
+				},
+			},
+			{
+				Name:        "admin",
+				Usage:       "let a user change every deck",
+				Description: "soyfr user admin --revoke anna",
+				Flags: []cli.Flag{
+					cli.BoolFlag{Name: "revoke", Usage: "take the admin rights back"},
+				},
+				Action: func(c *cli.Context) {
+					if len(c.Args()) != 1 {
+						cli.ShowCommandHelp(c, "admin")
+						return
+					}
+
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					if err := db.SetUserAdmin(connection, c.Args().First(), !c.Bool("revoke")); err != nil {
+						log.Fatal(err)
+					}
+
+					log.Printf("Changed the admin rights of %s\n", c.Args().First())
+				},
+			},
+			{
+				Name:  "set-password",
+				Usage: "replace the password of a registered user",
+				Flags: []cli.Flag{passwordFlag},
+				Action: func(c *cli.Context) {
+					if len(c.Args()) != 1 {
+						cli.ShowCommandHelp(c, "set-password")
+						return
+					}
+
+					connection := connect(*conf)
+					defer connection.Session.Close()
+
+					if err := db.SetUserPassword(connection, c.Args().First(), readPassword(c)); err != nil {
+						log.Fatal(err)
+					}
+
+					log.Printf("Changed the password of %s\n", c.Args().First())
+				},
+			},
+		},
+	}
+}
+
+//configCommand shows the configuration after reading all sources
+func configCommand(conf *config.Config) cli.Command {
+	return cli.Command{
+		Name:  "config",
+		Usage: "show the configuration",
+		Subcommands: []cli.Command{
+			{
+				Name:  "print",
+				Usage: "print the effective configuration as yaml",
+				Action: func(c *cli.Context) {
+					if err := conf.Print(os.Stdout); err != nil {
+						log.Fatal(err)
+					}
+				},
+			},
+		},
+	}
+}
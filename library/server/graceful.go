@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//drainTimeout is how long running requests may take after a shutdown signal
+const drainTimeout = 10 * time.Second
+
+//gracefulServer serves http until it is stopped and then waits for the
+//running requests, idle keep-alive connections are closed right away.
+//Hijacked connections like websockets are not waited for.
+type gracefulServer struct {
+	sync.Mutex
+	server   *http.Server
+	timeout  time.Duration
+	active   sync.WaitGroup
+	idle     map[net.Conn]bool
+	stopping bool
+}
+
+func newGracefulServer(handler http.Handler) *gracefulServer {
+	g := &gracefulServer{timeout: drainTimeout, idle: make(map[net.Conn]bool)}
+	g.server = &http.Server{Handler: handler, ConnState: g.track}
+
+	return g
+}
+
+//track follows the state of every connection
+func (g *gracefulServer) track(conn net.Conn, state http.ConnState) {
+	g.Lock()
+	defer g.Unlock()
+
+	switch state {
+	case http.StateNew:
+		g.active.Add(1)
+		g.idle[conn] = true
+	case http.StateActive:
+		delete(g.idle, conn)
+	case http.StateIdle:
+		if g.stopping {
+			conn.Close()
+			return
+		}
+
+		g.idle[conn] = true
+	case http.StateHijacked, http.StateClosed:
+		delete(g.idle, conn)
+		g.active.Done()
+	}
+}
+
+//Serve handles requests on the listener until a signal arrives on stop.
+//Then onStop is called, no new connections are accepted and Serve
+//returns once all running requests are done or the timeout is over.
+func (g *gracefulServer) Serve(listener net.Listener, stop <-chan os.Signal, onStop func()) error {
+	failed := make(chan error, 1)
+	go func() {
+		failed <- g.server.Serve(listener)
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down\n", sig)
+	}
+
+	onStop()
+
+	g.Lock()
+	g.stopping = true
+	g.server.SetKeepAlivesEnabled(false)
+	for conn := range g.idle {
+		conn.Close()
+	}
+	g.Unlock()
+
+	listener.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		g.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(g.timeout):
+		return errors.New("Running requests did not finish in time")
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Graceful server", func() {
+	var (
+		listener net.Listener
+		stop     chan os.Signal
+		release  chan struct{}
+		started  chan struct{}
+		server   *gracefulServer
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		stop = make(chan os.Signal, 1)
+		release = make(chan struct{})
+		started = make(chan struct{}, 1)
+		server = newGracefulServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			w.Write([]byte("done"))
+		}))
+	})
+
+	It("Should finish running requests before it returns", func() {
+		stopped := false
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(listener, stop, func() { stopped = true })
+		}()
+
+		response := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			resp, err := http.Get("http://" + listener.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			response <- string(body)
+		}()
+
+		Eventually(started).Should(Receive())
+		stop <- syscall.SIGTERM
+		Consistently(served, 100*time.Millisecond).ShouldNot(Receive())
+
+		_, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).To(HaveOccurred())
+
+		close(release)
+		Eventually(response).Should(Receive(Equal("done")))
+		Eventually(served).Should(Receive(BeNil()))
+		Expect(stopped).To(BeTrue())
+	})
+
+	It("Should give up on requests that take too long", func() {
+		server.timeout = 50 * time.Millisecond
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Serve(listener, stop, func() {})
+		}()
+
+		go http.Get("http://" + listener.Addr().String())
+		Eventually(started).Should(Receive())
+		stop <- syscall.SIGINT
+
+		Eventually(served).Should(Receive(HaveOccurred()))
+		close(release)
+	})
+})
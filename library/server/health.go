@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/manyminds/soyfr/library/db"
+	"github.com/manyminds/soyfr/library/metrics"
+	"gopkg.in/mgo.v2"
+)
+
+//pingTimeout is how long the readiness check waits for mongo
+const pingTimeout = 2 * time.Second
+
+//health answers the liveness and readiness checks
+type health struct {
+	session  *mgo.Session
+	stopping int32
+}
+
+//stop makes the server report that it is not ready anymore
+func (h *health) stop() {
+	atomic.StoreInt32(&h.stopping, 1)
+}
+
+//live reports that the process is running
+func (h *health) live(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+//ready reports if the server can handle requests, which it can not
+//while shutting down or without a connection to mongo
+func (h *health) ready(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.stopping) == 1 {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	session := h.session.Copy()
+	defer session.Close()
+	session.SetSyncTimeout(pingTimeout)
+	session.SetSocketTimeout(pingTimeout)
+
+	if err := session.Ping(); err != nil {
+		http.Error(w, "database unreachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Write([]byte("ok\n"))
+}
+
+//registerGameMetrics adds the metrics of the socket server
+func registerGameMetrics(registry *metrics.Registry, sockets *db.Sockets) {
+	registry.NewGaugeFunc("soyfr_socket_connections", "Number of connected sockets.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(sockets.Connections())}}
+	})
+
+	registry.NewGaugeFunc("soyfr_socket_room_connections", "Number of sockets in a game room.", func() []metrics.Sample {
+		var samples []metrics.Sample
+		for room, count := range sockets.RoomConnections() {
+			samples = append(samples, metrics.Sample{Labels: metrics.Labels{"room": room}, Value: float64(count)})
+		}
+
+		return samples
+	})
+
+	registry.NewGaugeFunc("soyfr_games_running", "Number of games whose turns are being played.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(sockets.RunningGames())}}
+	})
+
+	registry.NewGaugeFunc("soyfr_votes_per_minute", "Number of votes cast in the last minute.", func() []metrics.Sample {
+		lastMinute, _ := sockets.Votes()
+		return []metrics.Sample{{Value: float64(lastMinute)}}
+	})
+
+	registry.NewCounterFunc("soyfr_votes_total", "Number of votes cast since the server started.", func() []metrics.Sample {
+		_, total := sockets.Votes()
+		return []metrics.Sample{{Value: float64(total)}}
+	})
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	It("Should be live and stop being ready on shutdown", func() {
+		checks := &health{}
+		request, _ := http.NewRequest("GET", "/healthz", nil)
+
+		response := httptest.NewRecorder()
+		checks.live(response, request)
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		checks.stop()
+		response = httptest.NewRecorder()
+		checks.ready(response, request)
+		Expect(response.Code).To(Equal(http.StatusServiceUnavailable))
+
+		response = httptest.NewRecorder()
+		checks.live(response, request)
+		Expect(response.Code).To(Equal(http.StatusOK))
+	})
+})
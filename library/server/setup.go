@@ -4,29 +4,23 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/googollee/go-socket.io"
 	"github.com/lucas-clemente/go-http-logger"
+	"github.com/manyminds/soyfr/library/config"
 	"github.com/manyminds/soyfr/library/db"
+	"github.com/manyminds/soyfr/library/metrics"
 	"github.com/maxwellhealth/bongo"
 )
 
-const (
-	//EnvServerPort constant contains the name for the env variable to define the port
-	//where the server listens.
-	EnvServerPort = "SOYFR_SERVER_PORT"
-	//EnvConnectionURI is the mgo uri to the database or replica set
-	EnvConnectionURI = "SOYFR_CONNECTION_URI"
-	//EnvDatabase constant is the mongo database name for this application.
-	EnvDatabase = "SOYFR_DATABASE"
-	//EnvResourceFiles is the relative or absolute path to the folder where
-	//the static frontend files are
-	EnvResourceFiles = "SOYFR_RESOURCE_FILES"
-)
-
 //wrapFileHandler adds a wildcard to index.html if there are
 //static routes defined such as /fish or /some-seo-route
 //files with extension will still be delivered normally.
@@ -59,7 +53,7 @@ func wrapFileHandler(distPath string, handler http.Handler) http.HandlerFunc {
 //the normal http mux functionality of go
 func wrapAPIHandler(handler http.Handler, prefix string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.URL.Path = strings.Replace(r.RequestURI, prefix, "", 1)
+		r.URL.Path = strings.Replace(r.URL.Path, prefix, "", 1)
 		handler.ServeHTTP(w, r)
 	}
 }
@@ -74,54 +68,107 @@ func GetApplication() *cli.App {
 	app.Email = "info@manyminds.de"
 	app.Version = "Development"
 
-	serverPortFlag := cli.IntFlag{
-		Name:   "port",
-		Value:  8800,
-		Usage:  "9000",
-		EnvVar: EnvServerPort,
+	//the configuration is loaded before any command runs
+	conf := &config.Config{}
+	app.Flags = config.Flags()
+	app.Before = func(c *cli.Context) error {
+		loaded, err := config.Load(c)
+		*conf = loaded
+		return err
 	}
 
-	databaseString := cli.StringFlag{
-		Name:   "database",
-		Value:  "soyfr_development",
-		Usage:  "some name for a database",
-		EnvVar: EnvDatabase,
+	app.Commands = []cli.Command{
+		serveCommand(conf),
+		migrateCommand(conf),
+		seedCommand(conf),
+		userCommand(conf),
+		importDeckCommand(conf),
+		configCommand(conf),
 	}
-
-	distPathString := cli.StringFlag{
-		Name:   "resourceDirectory",
-		Value:  "./public",
-		Usage:  "path to the resource files",
-		EnvVar: EnvResourceFiles,
+	//without a command the server is started as before
+	app.Action = func(c *cli.Context) {
+		serve(*conf)
 	}
 
-	app.Flags = []cli.Flag{serverPortFlag, databaseString, distPathString}
-	app.Action = func(c *cli.Context) {
-		connectionString := db.GetConnectionString()
-		database := c.String("database")
-		distPath := c.String("resourceDirectory")
-		serverPort := c.Int("port")
+	return app
+}
 
-		log.Printf("Mongo connection on %s\n", connectionString)
+//serve starts the server, pending migrations are applied first if configured
+func serve(conf config.Config) {
+	log.Printf("Mongo connection on %s\n", conf.ConnectionURI)
 
-		startApplication(connectionString, database, distPath, serverPort)
+	if conf.Migrate {
+		migrate(conf, false)
 	}
 
-	return app
+	if err := startApplication(conf); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Server stopped")
 }
 
-func startApplication(connectionString, database, distPath string, serverPort int) {
-	config := bongo.Config{
-		ConnectionString: connectionString,
-		Database:         database,
+//startApplication serves the application until the process receives
+//SIGINT or SIGTERM, the running games are stored before it returns
+func startApplication(conf config.Config) error {
+	connection, err := bongo.Connect(conf.Bongo())
+	if err != nil {
+		return err
 	}
 
+	defer connection.Session.Close()
+
+	var adaptor socketio.BroadcastAdaptor
+	if conf.Broadcast == config.BroadcastMongo {
+		mongoAdaptor, err := db.NewMongoAdaptor(connection)
+		if err != nil {
+			return err
+		}
+
+		defer mongoAdaptor.Close()
+		adaptor = mongoAdaptor
+	}
+
+	auth := db.NewAuthenticator(conf.SessionSecret, db.DefaultTokenLifetime)
+	sockets, err := db.BootstrapWebsocket(connection, auth, adaptor, conf.ChatWords, time.Duration(conf.GracePeriod)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	if err := sockets.Resume(); err != nil {
+		return err
+	}
+
+	registry := metrics.NewRegistry()
+	requests := registry.NewHTTP("/api/", "/s/", "/healthz", "/readyz", "/metrics")
+	registerGameMetrics(registry, sockets)
+	checks := &health{session: connection.Session}
+
 	mux := http.NewServeMux()
-	fileHandler := http.FileServer(http.Dir(distPath))
-	mux.Handle("/s/", wrapAPIHandler(db.BootstrapWebsocket(&config), "/s"))
-	mux.Handle("/api/", wrapAPIHandler(db.BootstrapAPI(&config), "/api"))
-	mux.Handle("/", wrapFileHandler(distPath, fileHandler))
+	fileHandler := http.FileServer(http.Dir(conf.ResourceDirectory))
+	mux.Handle("/s/", wrapAPIHandler(sockets, "/s"))
+	mux.Handle("/api/v1/moderation/", wrapAPIHandler(sockets.Moderation(), "/api"))
+	mux.Handle("/api/", wrapAPIHandler(db.BootstrapAPI(connection, auth, conf.PublicURL), "/api"))
+	mux.HandleFunc("/healthz", checks.live)
+	mux.HandleFunc("/readyz", checks.ready)
+	mux.Handle("/metrics", registry)
+	mux.Handle("/", wrapFileHandler(conf.ResourceDirectory, fileHandler))
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.Port))
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	log.Printf("Server started on port :%d\n", conf.Port)
+
+	shutdown := func() {
+		checks.stop()
+		sockets.Shutdown()
+	}
 
-	log.Printf("Server started on port :%d\n", serverPort)
-	http.ListenAndServe(fmt.Sprintf(":%d", serverPort), logger.Logger(mux))
+	return newGracefulServer(logger.Logger(requests.Instrument(mux))).Serve(listener, stop, shutdown)
 }
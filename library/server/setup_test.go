@@ -0,0 +1,35 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Setup", func() {
+	It("Should serve the frontend for invite links and files as they are", func() {
+		dir, err := ioutil.TempDir("", "soyfr-public")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>app</html>"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "app.js"), []byte("bootstrap()"), 0600)).To(Succeed())
+		handler := wrapFileHandler(dir, http.FileServer(http.Dir(dir)))
+
+		request, _ := http.NewRequest("GET", "/join/BEER42", nil)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(Equal("<html>app</html>"))
+
+		request, _ = http.NewRequest("GET", "/app.js", nil)
+		response = httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		Expect(response.Body.String()).To(Equal("bootstrap()"))
+	})
+})
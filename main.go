@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"os"
 
 	"github.com/manyminds/soyfr/library/server"
@@ -8,5 +9,7 @@ import (
 
 func main() {
 	app := server.GetApplication()
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
 }
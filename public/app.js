@@ -14,6 +14,9 @@ var __metadata = (this && this.__metadata) || function (k, v) {
 var angular2_1 = require('angular2/angular2');
 var UserComponent = (function () {
     function UserComponent() {
+        // invite links and their qr codes point to /join/<code>
+        var match = /^\/join\/([^\/]+)/.exec(window.location.pathname);
+        this.joinCode = match ? decodeURIComponent(match[1]).toUpperCase() : '';
     }
     UserComponent.prototype.selectUsername = function (name) {
         this.username = name;
@@ -14,8 +14,13 @@ import {Component,View, bootstrap, NgFor} from 'angular2/angular2';
 // Component controller
 class UserComponent {
   username: string;
+  joinCode: string;
 
-  constructor() {},
+  constructor() {
+    // invite links and their qr codes point to /join/<code>
+    var match = /^\/join\/([^\/]+)/.exec(window.location.pathname);
+    this.joinCode = match ? decodeURIComponent(match[1]).toUpperCase() : '';
+  },
 
   selectUsername(name: string) {
     this.username = name;